@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"greenlight.honganhpham.net/internal/assert"
+	"greenlight.honganhpham.net/internal/data"
+	"greenlight.honganhpham.net/internal/mocks"
+)
+
+func TestCreateAPIKeyHandler(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+	ts := newAuthenticatedTestServer(t, app, mocks.ActivatedUser)
+	defer ts.Close()
+
+	tests := []struct {
+		name           string
+		inputJSON      string
+		expectedStatus int
+	}{
+		{
+			name:           "Valid Key",
+			inputJSON:      `{"name": "importer", "permissions": ["movies:read"]}`,
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Permission Not Held",
+			inputJSON:      `{"name": "importer", "permissions": ["admin:permissions"]}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Missing Name",
+			inputJSON:      `{"permissions": ["movies:read"]}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Missing Permissions",
+			inputJSON:      `{"name": "importer"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.post(t, APIKeyV1, []byte(tt.inputJSON))
+			assert.Equal(t, code, tt.expectedStatus)
+
+			if tt.expectedStatus == http.StatusCreated {
+				var response struct {
+					APIKey struct {
+						Key string `json:"key"`
+					} `json:"api_key"`
+				}
+
+				err := json.Unmarshal(body, &response)
+				assert.NilError(t, err)
+				assert.Equal(t, response.APIKey.Key != "", true)
+			}
+		})
+	}
+}
+
+func TestCreateAPIKeyHandlerScoped(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+	ts := newTestServer(t, app.authenticate(app))
+	defer ts.Close()
+
+	// The owner holds movies:write, but this key was only given movies:read
+	key, err := app.models.APIKeys.New(mocks.ActivatedUser.ID, "reader", data.Permissions{data.PermissionMoviesRead})
+	assert.NilError(t, err)
+
+	tests := []struct {
+		name           string
+		inputJSON      string
+		expectedStatus int
+	}{
+		{"Narrower Or Equal Key", `{"name": "child", "permissions": ["movies:read"]}`, http.StatusCreated},
+		{"Broader Key", `{"name": "child", "permissions": ["movies:read", "movies:write"]}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+APIKeyV1, strings.NewReader(tt.inputJSON))
+			assert.NilError(t, err)
+			req.Header.Set("Authorization", "ApiKey "+key.Plaintext)
+
+			rs, err := ts.Client().Do(req)
+			assert.NilError(t, err)
+			rs.Body.Close()
+
+			assert.Equal(t, rs.StatusCode, tt.expectedStatus)
+		})
+	}
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+
+	key, err := app.models.APIKeys.New(mocks.ActivatedUser.ID, "importer", data.Permissions{data.PermissionMoviesRead})
+	assert.NilError(t, err)
+
+	ts := newTestServer(t, app.authenticate(app))
+	defer ts.Close()
+
+	tests := []struct {
+		name           string
+		method         string
+		authorization  string
+		expectedStatus int
+	}{
+		{
+			name:           "Scoped Permission",
+			method:         http.MethodGet,
+			authorization:  "ApiKey " + key.Plaintext,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Permission Outside Key Scope",
+			method:         http.MethodDelete,
+			authorization:  "ApiKey " + key.Plaintext,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Unknown Key",
+			method:         http.MethodGet,
+			authorization:  "ApiKey glk_AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Malformed Key",
+			method:         http.MethodGet,
+			authorization:  "ApiKey short",
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+MovieV1+"/1", nil)
+			assert.NilError(t, err)
+			req.Header.Set("Authorization", tt.authorization)
+
+			rs, err := ts.Client().Do(req)
+			assert.NilError(t, err)
+			rs.Body.Close()
+
+			assert.Equal(t, rs.StatusCode, tt.expectedStatus)
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"greenlight.honganhpham.net/internal/data"
+	"greenlight.honganhpham.net/internal/validator"
+)
+
+// Append an event to the audit trail, failures are logged but never fail the request being audited
+func (app *application) audit(r *http.Request, event *data.AuditEvent) {
+	event.IP = app.clientIP(r)
+
+	err := app.models.Audit.Insert(event)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+func (app *application) listAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.AuditFilters
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.AuditFilters.ActorID = int64(app.readInt(qs, "user_id", 0, v))
+	input.AuditFilters.Action = app.readString(qs, "action", "")
+	input.AuditFilters.From = app.readTime(qs, "from", v)
+	input.AuditFilters.To = app.readTime(qs, "to", v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-id") // Newest first
+	input.Filters.SortSafeList = []string{"id", "-id"}
+
+	data.ValidateAuditFilters(v, input.AuditFilters)
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	events, metadata, err := app.models.Audit.GetAll(input.AuditFilters, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"audit_events": events, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Recompute the hash chain, reporting the first event after a deleted or modified one
+// Deleting the newest events leaves a valid chain, so the head is returned to compare against a recorded one
+func (app *application) verifyAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	brokenAt, head, err := app.models.Audit.Verify()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"valid": brokenAt == 0}
+	if brokenAt != 0 {
+		env["broken_at"] = brokenAt
+	} else {
+		env["head"] = head
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Record why a login was refused, user is nil when the email is unknown
+func (app *application) auditFailedLogin(r *http.Request, email string, user *data.User, err error) {
+	var reason string
+
+	switch {
+	case errors.Is(err, errAccountLocked):
+		reason = "locked"
+	case errors.Is(err, data.ErrRecordNotFound):
+		reason = "unknown_email"
+	case errors.Is(err, errInvalidCredentials):
+		reason = "invalid_password"
+	case errors.Is(err, errInvalidOTP):
+		reason = "invalid_otp"
+	default:
+		return // Server errors say nothing about the credentials
+	}
+
+	event := &data.AuditEvent{
+		Action:   data.AuditLoginFailed,
+		Metadata: map[string]string{"email": email, "reason": reason},
+	}
+
+	if user != nil {
+		event.ActorID = user.ID
+	}
+
+	app.audit(r, event)
+}
+
+// Log the head of the chain every interval, so the log holds anchors a truncated chain can be checked against
+func (app *application) startAuditAnchor(stop <-chan struct{}) <-chan struct{} {
+	return app.runPeriodically(app.config.auditAnchorInterval, stop, app.logAuditHead)
+}
+
+func (app *application) logAuditHead() {
+	head, err := app.models.Audit.Head()
+	if err != nil {
+		app.logger.Error(err, map[string]string{
+			"task": "audit anchor",
+		})
+		return
+	}
+
+	app.logger.Info("audit chain head", map[string]string{
+		"count": strconv.FormatInt(head.Count, 10),
+		"id":    strconv.FormatInt(head.ID, 10),
+		"hash":  hex.EncodeToString(head.Hash),
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"greenlight.honganhpham.net/internal/assert"
+	"greenlight.honganhpham.net/internal/data"
+	"greenlight.honganhpham.net/internal/mocks"
+)
+
+func TestAuditEvents(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+
+	loginServer := newTestServer(t, app)
+	defer loginServer.Close()
+
+	admin := newAuthenticatedTestServer(t, app, mocks.AdminUser)
+	defer admin.Close()
+
+	login := func(password string) {
+		loginServer.post(t, TokenV1+"/authentication", []byte(`{"email": "`+mocks.ActivatedUser.Email+`", "password": "`+password+`"}`))
+	}
+
+	login("wrongpassword")
+	login(mocks.MockPassword)
+
+	list := func(query string) []data.AuditEvent {
+		code, _, body := admin.get(t, AuditV1+query)
+		assert.Equal(t, code, http.StatusOK)
+
+		var response struct {
+			Events []data.AuditEvent `json:"audit_events"`
+		}
+
+		err := json.Unmarshal([]byte(body), &response)
+		assert.NilError(t, err)
+
+		return response.Events
+	}
+
+	// Newest first
+	events := list("")
+	if len(events) != 2 {
+		t.Fatalf("got %d events; want 2", len(events))
+	}
+	assert.Equal(t, events[0].Action, data.AuditLogin)
+	assert.Equal(t, events[1].Action, data.AuditLoginFailed)
+	assert.Equal(t, events[1].ActorID, mocks.ActivatedUser.ID)
+	assert.Equal(t, events[1].Metadata["reason"], "invalid_password")
+	assert.Equal(t, events[1].IP, "127.0.0.1")
+
+	events = list("?action=" + data.AuditLoginFailed)
+	if len(events) != 1 {
+		t.Fatalf("got %d events; want 1", len(events))
+	}
+	assert.Equal(t, events[0].Action, data.AuditLoginFailed)
+
+	events = list("?user_id=999")
+	assert.Equal(t, len(events), 0)
+
+	events = list("?from=2100-01-01T00:00:00Z")
+	assert.Equal(t, len(events), 0)
+
+	code, _, body := admin.get(t, AuditV1+"/verify")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `"valid": true`)
+	assert.StringContains(t, body, `"count": 2`)
+
+	// The head is logged so a later verification can tell whether the newest events were deleted
+	app.logAuditHead()
+	assert.StringContains(t, tl.GetLogOutput(), "audit chain head")
+
+	for _, query := range []string{"?action=nope", "?from=yesterday", "?sort=action", "?from=2024-02-01T00:00:00Z&to=2024-01-01T00:00:00Z"} {
+		code, _, _ := admin.get(t, AuditV1+query)
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+	}
+
+	// Only admins may read the trail
+	user := newAuthenticatedTestServer(t, app, mocks.ActivatedUser)
+	defer user.Close()
+
+	code, _, _ = user.get(t, AuditV1)
+	assert.Equal(t, code, http.StatusForbidden)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+type TokenCleanupConfig struct {
+	interval  time.Duration
+	batchSize int
+}
+
+type AccountCleanupConfig struct {
+	interval time.Duration
+	grace    time.Duration // How long a deleted account can still be restored by support
+}
+
+// Run fn every interval until the stop channel is closed
+// The worker is tracked by app.wg so graceful shutdown waits for an in-flight run to finish
+// The returned channel is signalled after each run without blocking, so callers may ignore it
+func (app *application) runPeriodically(interval time.Duration, stop <-chan struct{}, fn func()) <-chan struct{} {
+	done := make(chan struct{}, 1)
+
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				fn()
+
+				select {
+				case done <- struct{}{}:
+				default:
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return done
+}
+
+// Stale login attempts are purged along with the tokens, both are rows that simply expire
+func (app *application) startTokenCleanup(stop <-chan struct{}) <-chan struct{} {
+	return app.runPeriodically(app.config.tokenCleanup.interval, stop, func() {
+		app.purgeExpiredTokens()
+		app.purgeStaleLoginAttempts()
+	})
+}
+
+func (app *application) startAccountCleanup(stop <-chan struct{}) <-chan struct{} {
+	return app.runPeriodically(app.config.accountCleanup.interval, stop, app.purgeDeletedAccounts)
+}
+
+// Delete expired tokens batch by batch until a batch comes back short
+func (app *application) purgeExpiredTokens() {
+	var total int64
+
+	for {
+		n, err := app.models.Token.DeleteExpired(app.config.tokenCleanup.batchSize)
+		if err != nil {
+			app.logger.Error(err, map[string]string{
+				"task": "token cleanup",
+			})
+			return
+		}
+
+		total += n
+
+		if n < int64(app.config.tokenCleanup.batchSize) {
+			break
+		}
+	}
+
+	app.logger.Info("expired tokens purged", map[string]string{
+		"rows": strconv.FormatInt(total, 10),
+	})
+}
+
+// Hard delete accounts whose grace period is over, the database cascades to their tokens and permissions
+func (app *application) purgeDeletedAccounts() {
+	n, err := app.models.Users.DeleteSoftDeleted(time.Now().Add(-app.config.accountCleanup.grace))
+	if err != nil {
+		app.logger.Error(err, map[string]string{
+			"task": "account cleanup",
+		})
+		return
+	}
+
+	app.logger.Info("deleted accounts purged", map[string]string{
+		"rows": strconv.FormatInt(n, 10),
+	})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"greenlight.honganhpham.net/internal/assert"
+	"greenlight.honganhpham.net/internal/data"
+)
+
+func TestPurgeExpiredTokens(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+	app.config.tokenCleanup = TokenCleanupConfig{interval: time.Millisecond, batchSize: 2}
+
+	// Three expired tokens need two batches, the valid one must survive
+	for userID, ttl := range map[int64]time.Duration{1: -time.Hour, 2: -time.Minute, 3: -time.Second, 4: time.Hour} {
+		_, err := app.models.Token.New(userID, ttl, data.ScopeAuthentication)
+		assert.NilError(t, err)
+	}
+
+	stop := make(chan struct{})
+	waitForRun(t, app.startTokenCleanup(stop))
+	close(stop)
+	app.wg.Wait()
+
+	deleted, err := app.models.Token.DeleteExpired(10)
+	assert.NilError(t, err)
+	assert.Equal(t, deleted, int64(0))
+
+	assert.StringContains(t, tl.GetLogOutput(), "expired tokens purged")
+	assert.StringContains(t, tl.GetLogOutput(), `"rows":"3"`)
+}
+
+func TestPurgeStaleLoginAttempts(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+	app.config.tokenCleanup = TokenCleanupConfig{interval: time.Millisecond, batchSize: 10}
+
+	since := time.Now().Add(-app.config.lockout.window)
+
+	// Only the unlocked row outside the window goes
+	for _, email := range []string{"stale@example.com", "locked@example.com", "recent@example.com"} {
+		_, err := app.models.LoginAttempts.RecordFailure(email, since)
+		assert.NilError(t, err)
+	}
+
+	for _, email := range []string{"stale@example.com", "locked@example.com"} {
+		attempt, err := app.models.LoginAttempts.Get(email)
+		assert.NilError(t, err)
+		attempt.LastFailedAt = since.Add(-time.Minute)
+	}
+
+	err := app.models.LoginAttempts.Lock("locked@example.com", time.Now().Add(time.Hour))
+	assert.NilError(t, err)
+
+	stop := make(chan struct{})
+	waitForRun(t, app.startTokenCleanup(stop))
+	close(stop)
+	app.wg.Wait()
+
+	_, err = app.models.LoginAttempts.Get("stale@example.com")
+	assert.Equal(t, err, data.ErrRecordNotFound)
+
+	for _, email := range []string{"locked@example.com", "recent@example.com"} {
+		_, err := app.models.LoginAttempts.Get(email)
+		assert.NilError(t, err)
+	}
+
+	assert.StringContains(t, tl.GetLogOutput(), "stale login attempts purged")
+}
+
+func TestPurgeDeletedAccounts(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+	app.config.accountCleanup = AccountCleanupConfig{interval: time.Millisecond, grace: time.Hour}
+
+	stop := make(chan struct{})
+	waitForRun(t, app.startAccountCleanup(stop))
+	close(stop)
+	app.wg.Wait()
+
+	assert.StringContains(t, tl.GetLogOutput(), "deleted accounts purged")
+}
+
+func waitForRun(t *testing.T, done <-chan struct{}) {
+	t.Helper()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("worker did not run")
+	}
+}
@@ -12,6 +12,9 @@ type contextKey string
 // To be used as a key to get and set user information in the request context
 const userContextKey = contextKey("user")
 
+// Permissions carried by a stateless token or scoped to an API key, overriding the database lookup in requirePermission
+const permissionsContextKey = contextKey("permissions")
+
 // Return a copy of the context with user data
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
@@ -26,3 +29,14 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+func (app *application) contextSetPermissions(r *http.Request, permissions data.Permissions) *http.Request {
+	ctx := context.WithValue(r.Context(), permissionsContextKey, permissions)
+	return r.WithContext(ctx)
+}
+
+// The boolean reports whether the permissions were set, i.e. whether the request used a stateless token or an API key
+func (app *application) contextGetPermissions(r *http.Request) (data.Permissions, bool) {
+	permissions, ok := r.Context().Value(permissionsContextKey).(data.Permissions)
+	return permissions, ok
+}
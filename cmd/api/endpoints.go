@@ -6,4 +6,8 @@ const (
 	UserV1        = "/v1/users"
 	HealthCheckV1 = "/v1/healthcheck"
 	TokenV1       = "/v1/tokens"
+	APIKeyV1      = "/v1/api-keys"
+	OAuthV1       = "/v1/oauth"
+	AuditV1       = "/v1/audit"
+	InvitationV1  = "/v1/invitations"
 )
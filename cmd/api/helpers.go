@@ -5,12 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
+	"greenlight.honganhpham.net/internal/data"
 	"greenlight.honganhpham.net/internal/validator"
 )
 
@@ -94,6 +96,36 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 	return nil
 }
 
+// Split an "Authorization: <scheme> <credentials>" header
+func (app *application) readAuthorizationHeader(r *http.Request) (string, string, bool) {
+	headerParts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(headerParts) != 2 {
+		return "", "", false
+	}
+
+	return headerParts[0], headerParts[1], true
+}
+
+// Return the IP address of the client, the server is not expected to run behind a proxy
+func (app *application) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return ip
+}
+
+// Extract the token from an "Authorization: Bearer <token>" header
+func (app *application) readBearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := app.readAuthorizationHeader(r)
+	if !ok || scheme != "Bearer" {
+		return "", false
+	}
+
+	return token, true
+}
+
 // Return a string value from a query string
 func (app *application) readString(qs url.Values, key, defaultValue string) string {
 	s := qs.Get(key)
@@ -135,31 +167,96 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 
 }
 
-// Ensure consistent processing time for sensitive operations
-func (app *application) consistentTimeHandler(operation func() error, minDuration time.Duration) error {
+// Read a boolean such as true, false, 1 or 0
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
 
-	startTime := time.Now()
+	if s == "" {
+		return defaultValue
+	}
 
-	done := make(chan error, 1)
+	b, err := strconv.ParseBool(s)
 
-	go func() {
-		done <- operation()
-	}()
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
 
-	// Wait for one of multiple channel operations to complete
-	select {
-	// Case 1: Operation completes and sends result to done channel
-	case err := <-done:
-		elapsed := time.Since(startTime)
-		// Wait for the remaining time
-		if elapsed < minDuration {
-			time.Sleep(minDuration - elapsed)
-		}
-		return err
-	// Case 2: Operation takes too long (timeout)
-	case <-time.After(minDuration * 2):
-		return errors.New("operation timed out")
+	return b
+}
+
+// Read the optional <key>_min and <key>_max bounds of a range
+func (app *application) readIntRange(qs url.Values, key string, v *validator.Validator) data.IntRange {
+	var r data.IntRange
+
+	if qs.Get(key+"_min") != "" {
+		min := app.readInt(qs, key+"_min", 0, v)
+		r.Min = &min
 	}
+
+	if qs.Get(key+"_max") != "" {
+		max := app.readInt(qs, key+"_max", 0, v)
+		r.Max = &max
+	}
+
+	return r
+}
+
+// Read an RFC 3339 timestamp, nil when the key is missing
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) *time.Time {
+	s := qs.Get(key)
+
+	if s == "" {
+		return nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp")
+		return nil
+	}
+
+	return &t
+}
+
+// Switch the filters to cursor mode, decoding the cursor and defaulting the sort to the one it was issued for
+func (app *application) readCursor(qs url.Values, f *data.Filters, v *validator.Validator) {
+	f.CursorMode = true
+	f.CursorKey = []byte(app.config.cursorKey)
+
+	v.Check(!qs.Has("page"), "page", "cannot be combined with cursor")
+
+	s := qs.Get("cursor")
+	if s == "" {
+		return
+	}
+
+	cursor, err := data.DecodeCursor(s, f.CursorKey)
+	if err != nil {
+		v.AddError("cursor", "invalid cursor")
+		return
+	}
+
+	f.Cursor = cursor
+
+	if !qs.Has("sort") {
+		f.Sort = cursor.Sort
+	}
+}
+
+// Run operation in the caller's goroutine and hold its result back until at least minDuration has passed
+// There is no cutoff, so nothing it does can still be running once the response is written
+func consistentTime[T any](minDuration time.Duration, operation func() T) T {
+	startTime := time.Now()
+
+	result := operation()
+
+	if elapsed := time.Since(startTime); elapsed < minDuration {
+		time.Sleep(minDuration - elapsed)
+	}
+
+	return result
 }
 
 func (app *application) background(fn func()) {
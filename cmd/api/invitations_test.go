@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"greenlight.honganhpham.net/internal/assert"
+	"greenlight.honganhpham.net/internal/data"
+	"greenlight.honganhpham.net/internal/mocks"
+)
+
+func TestInvitations(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+	app.config.registration.mode = registrationModeInvite
+
+	ts := newTestServer(t, app)
+	defer ts.Close()
+
+	admin := newAuthenticatedTestServer(t, app, mocks.AdminUser)
+	defer admin.Close()
+
+	// Admins can only hand out permissions they hold, and existing users cannot be invited
+	code, _, _ := admin.post(t, InvitationV1, []byte(`{"email": "invitee@example.com", "permissions": ["admin:unknown"]}`))
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+
+	code, _, _ = admin.post(t, InvitationV1, []byte(`{"email": "`+mocks.ActivatedUser.Email+`"}`))
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+
+	code, _, body := admin.post(t, InvitationV1, []byte(`{"email": "invitee@example.com", "permissions": ["admin:audit"]}`))
+	assert.Equal(t, code, http.StatusCreated)
+
+	// The code is emailed to the invitee, never returned to the admin
+	var created map[string]map[string]any
+	err := json.Unmarshal(body, &created)
+	assert.NilError(t, err)
+	assert.Equal(t, created["invitation"]["email"], any("invitee@example.com"))
+	assert.Equal(t, created["invitation"]["code"], nil)
+
+	_, _, listBody := admin.get(t, InvitationV1)
+	assert.StringContains(t, listBody, "invitee@example.com")
+
+	invitations, err := app.models.Invitations.GetAll()
+	assert.NilError(t, err)
+	if len(invitations) != 1 {
+		t.Fatalf("got %d invitations; want 1", len(invitations))
+	}
+	invitation := invitations[0].Code
+
+	register := func(email, invitation string) (int, []byte) {
+		code, _, body := ts.post(t, UserV1, []byte(`{"name": "Invited User", "email": "`+email+`", "password": "pa55word", "invitation": "`+invitation+`"}`))
+		return code, body
+	}
+
+	code, _ = register("invitee@example.com", "")
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+
+	// Bound to the invited address
+	code, body = register("someone@example.com", invitation)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, string(body), "invalid, used or expired invitation")
+
+	code, body = register("invitee@example.com", invitation)
+	assert.Equal(t, code, http.StatusCreated)
+
+	var registered struct {
+		User data.User `json:"user"`
+	}
+	err = json.Unmarshal(body, &registered)
+	assert.NilError(t, err)
+
+	permissions, err := app.models.Permissions.GetAllForUser(registered.User.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, permissions.Include(data.PermissionMoviesRead), true)
+	assert.Equal(t, permissions.Include(data.PermissionAdminAudit), true)
+
+	// Single use
+	code, _ = register("invitee@example.com", invitation)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+
+	// Only admins may invite
+	user := newAuthenticatedTestServer(t, app, mocks.ActivatedUser)
+	defer user.Close()
+
+	code, _, _ = user.get(t, InvitationV1)
+	assert.Equal(t, code, http.StatusForbidden)
+}
+
+func TestCreateInvitationHandlerScoped(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+	ts := newTestServer(t, app.authenticate(app))
+	defer ts.Close()
+
+	// The admin holds movies:write, but this key was only given what it needs to invite readers
+	key, err := app.models.APIKeys.New(mocks.AdminUser.ID, "inviter", data.Permissions{data.PermissionAdminInvitations, data.PermissionMoviesRead})
+	assert.NilError(t, err)
+
+	tests := []struct {
+		name           string
+		inputJSON      string
+		expectedStatus int
+	}{
+		{"Narrower Or Equal Permissions", `{"email": "reader@example.com", "permissions": ["movies:read"]}`, http.StatusCreated},
+		{"Broader Permissions", `{"email": "writer@example.com", "permissions": ["movies:write"]}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+InvitationV1, strings.NewReader(tt.inputJSON))
+			assert.NilError(t, err)
+			req.Header.Set("Authorization", "ApiKey "+key.Plaintext)
+
+			rs, err := ts.Client().Do(req)
+			assert.NilError(t, err)
+			rs.Body.Close()
+
+			assert.Equal(t, rs.StatusCode, tt.expectedStatus)
+		})
+	}
+}
+
+func TestRegistrationClosed(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+	app.config.registration.mode = registrationModeClosed
+
+	ts := newTestServer(t, app)
+	defer ts.Close()
+
+	code, _, _ := ts.post(t, UserV1, []byte(`{"name": "John Doe", "email": "john@example.com", "password": "pa55word"}`))
+	assert.Equal(t, code, http.StatusForbidden)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"greenlight.honganhpham.net/internal/data"
+)
+
+type LockoutConfig struct {
+	threshold   int           // Failed attempts allowed before the first lockout
+	duration    time.Duration // First lockout, doubled for every further failure
+	maxDuration time.Duration
+	window      time.Duration // Failures older than this are forgotten, and their rows purged once unlocked
+}
+
+var (
+	errAccountLocked      = errors.New("account locked")
+	errInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Return how long the email is still locked for, zero if it can log in
+func (app *application) lockedFor(email string) (time.Duration, error) {
+	attempt, err := app.models.LoginAttempts.Get(email)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	now := time.Now()
+	if !attempt.Locked(now) {
+		return 0, nil
+	}
+
+	return attempt.LockedUntil.Sub(now), nil
+}
+
+// Count a failed attempt and lock the email once the threshold is reached
+func (app *application) recordFailedLogin(email string) error {
+	attempt, err := app.models.LoginAttempts.RecordFailure(email, time.Now().Add(-app.config.lockout.window))
+	if err != nil {
+		return err
+	}
+
+	cfg := app.config.lockout
+
+	duration := data.LockoutDuration(attempt.FailedCount, cfg.threshold, cfg.duration, cfg.maxDuration)
+	if duration == 0 {
+		return nil
+	}
+
+	app.logger.Info("account locked after failed login attempts", map[string]string{
+		"email":        email,
+		"failed_count": strconv.Itoa(attempt.FailedCount),
+		"duration":     duration.String(),
+	})
+
+	return app.models.LoginAttempts.Lock(email, time.Now().Add(duration))
+}
+
+// Check the password of an already authenticated user before a sensitive change
+// Counted like a login, so someone holding a stolen token cannot guess the password without limit
+func (app *application) confirmPassword(user *data.User, password string) (time.Duration, error) {
+	retryAfter, err := app.lockedFor(user.Email)
+	if err != nil {
+		return 0, err
+	}
+
+	if retryAfter > 0 {
+		return retryAfter, errAccountLocked
+	}
+
+	match, err := user.Password.Matches(password)
+	if err != nil {
+		return 0, err
+	}
+
+	if !match {
+		if err := app.recordFailedLogin(user.Email); err != nil {
+			return 0, err
+		}
+		return 0, errInvalidCredentials
+	}
+
+	return 0, nil
+}
+
+// Forget failures outside the lockout window, keeping rows that are still locked
+func (app *application) purgeStaleLoginAttempts() {
+	n, err := app.models.LoginAttempts.DeleteStale(time.Now().Add(-app.config.lockout.window))
+	if err != nil {
+		app.logger.Error(err, map[string]string{
+			"task": "login attempt cleanup",
+		})
+		return
+	}
+
+	app.logger.Info("stale login attempts purged", map[string]string{
+		"rows": strconv.FormatInt(n, 10),
+	})
+}
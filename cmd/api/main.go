@@ -1,10 +1,14 @@
 package main
 
 import (
+	"crypto/rand"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
@@ -23,8 +27,18 @@ type config struct {
 	calldepth int
 	db        DBConfig
 
-	limiter rate.LimiterConfig
-	smtp    mailer.MailerConfig
+	limiter        rate.LimiterConfig
+	smtp           mailer.MailerConfig
+	tokenCleanup   TokenCleanupConfig
+	accountCleanup AccountCleanupConfig
+	auth           AuthConfig
+	lockout        LockoutConfig
+	password       PasswordConfig
+	registration   RegistrationConfig
+	cursorKey      string // Signs pagination cursors
+	auditKey       string // Keys the audit hash chain
+	// How often the head of the audit chain is logged
+	auditAnchorInterval time.Duration
 }
 
 type application struct {
@@ -55,7 +69,7 @@ func main() {
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
 	flag.IntVar(&cfg.calldepth, "calldepth", 3, "Log level call depth")
-	flag.IntVar(&cfg.limiter.RequestsPerSecond, "limiter-rps", 2, "Rate limiter maximum requests per second")
+	flag.Float64Var(&cfg.limiter.RequestsPerSecond, "limiter-rps", 2, "Rate limiter maximum requests per second")
 	flag.IntVar(&cfg.limiter.BurstSize, "limiter-burst", 4, "Rate limiter maximum burst size")
 	flag.IntVar(&cfg.limiter.QueueSize, "limiter-queue", 3, "Rate limiter maximum queue size")
 	flag.BoolVar(&cfg.limiter.Enabled, "limiter-enabled", true, "Enable rate limiter")
@@ -64,6 +78,37 @@ func main() {
 	flag.StringVar(&cfg.smtp.Username, "smtp-username", os.Getenv("MAILTRAP_SMTP_USERNAME"), "SMTP username")
 	flag.StringVar(&cfg.smtp.Password, "smtp-password", os.Getenv("MAILTRAP_SMTP_PASSWORD"), "SMTP password")
 	flag.StringVar(&cfg.smtp.Sender, "smtp-sender", os.Getenv("MAILTRAP_SMTP_SENDER"), "SMTP sender")
+	flag.DurationVar(&cfg.tokenCleanup.interval, "token-cleanup-interval", time.Hour, "Interval between expired token purges")
+	flag.IntVar(&cfg.tokenCleanup.batchSize, "token-cleanup-batch-size", 1000, "Maximum expired tokens deleted per query")
+	flag.DurationVar(&cfg.accountCleanup.interval, "account-cleanup-interval", time.Hour, "Interval between purges of deleted accounts")
+	flag.DurationVar(&cfg.accountCleanup.grace, "account-deletion-grace", 30*24*time.Hour, "How long a deleted account is kept before it is purged")
+	flag.StringVar(&cfg.auth.mode, "auth-mode", authModeStateful, "Authentication token mode (stateful|stateless)")
+	flag.StringVar(&cfg.auth.signingKey, "auth-signing-key", os.Getenv("GREENLIGHT_AUTH_SIGNING_KEY"), "HMAC key for stateless authentication tokens")
+	flag.BoolVar(&cfg.auth.statefulFallback, "auth-stateful-fallback", false, "Accept database tokens when running in stateless mode")
+	flag.BoolVar(&cfg.auth.revocationCheck, "auth-revocation-check", false, "In stateless mode, check on every request that the login was not revoked. Costs a database query per request, without it a signed token stays valid until it expires (see auth-access-ttl) after logout or a password change, and only refreshing is refused")
+	flag.DurationVar(&cfg.auth.accessTTL, "auth-access-ttl", 15*time.Minute, "Lifetime of authentication (access) tokens, at most 1h in stateless mode")
+	flag.DurationVar(&cfg.auth.refreshTTL, "auth-refresh-ttl", 30*24*time.Hour, "Lifetime of refresh tokens")
+	flag.BoolVar(&cfg.auth.basicEnabled, "auth-basic-enabled", false, "Accept HTTP Basic authentication with email and password")
+	flag.Float64Var(&cfg.auth.basicLimiter.RequestsPerSecond, "auth-basic-limiter-rps", 1, "HTTP Basic authentication maximum requests per second per client")
+	flag.IntVar(&cfg.auth.basicLimiter.BurstSize, "auth-basic-limiter-burst", 2, "HTTP Basic authentication maximum burst size per client")
+	flag.IntVar(&cfg.lockout.threshold, "lockout-threshold", 5, "Failed login attempts before an account is locked")
+	flag.DurationVar(&cfg.lockout.duration, "lockout-duration", time.Minute, "First account lockout duration, doubled on every further failure")
+	flag.DurationVar(&cfg.lockout.maxDuration, "lockout-max-duration", time.Hour, "Maximum account lockout duration")
+	flag.DurationVar(&cfg.lockout.window, "lockout-window", 24*time.Hour, "How long a failed login attempt counts towards the lockout")
+	flag.StringVar(&cfg.password.algorithm, "password-algorithm", passwordAlgorithmBcrypt, "Password hashing algorithm for new hashes (bcrypt|argon2id)")
+	flag.IntVar(&cfg.password.bcryptCost, "password-bcrypt-cost", 12, "bcrypt cost, lower existing hashes are upgraded on login")
+	flag.IntVar(&cfg.password.argon2Memory, "password-argon2-memory", 64*1024, "argon2id memory in KiB")
+	flag.IntVar(&cfg.password.argon2Iterations, "password-argon2-iterations", 3, "argon2id iterations")
+	flag.IntVar(&cfg.password.argon2Parallelism, "password-argon2-parallelism", 2, "argon2id threads")
+	flag.IntVar(&cfg.password.policy.minLength, "password-min-length", 8, "Minimum length of new passwords")
+	flag.BoolVar(&cfg.password.policy.rejectCommon, "password-reject-common", true, "Reject new passwords from the list of most common passwords")
+	flag.BoolVar(&cfg.password.policy.rejectPersonal, "password-reject-personal", true, "Reject new passwords containing the user's name or email")
+	flag.StringVar(&cfg.password.policy.breachDir, "password-breach-dir", "", "Directory of SHA-1 prefix files listing breached passwords")
+	flag.StringVar(&cfg.registration.mode, "registration-mode", registrationModeOpen, "Who can register (open|invite|closed)")
+	flag.DurationVar(&cfg.registration.invitationTTL, "invitation-ttl", 7*24*time.Hour, "Lifetime of invitation codes")
+	flag.StringVar(&cfg.cursorKey, "cursor-signing-key", os.Getenv("GREENLIGHT_CURSOR_SIGNING_KEY"), "HMAC key for pagination cursors")
+	flag.StringVar(&cfg.auditKey, "audit-signing-key", os.Getenv("GREENLIGHT_AUDIT_SIGNING_KEY"), "HMAC key for the audit hash chain")
+	flag.DurationVar(&cfg.auditAnchorInterval, "audit-anchor-interval", time.Hour, "Interval between logs of the audit chain head, which reveal deleted newest events")
 	debug := flag.Bool("debug", false, "Enable debug mode")
 	flag.Parse()
 
@@ -78,6 +123,82 @@ func main() {
 
 	// defer f.Close()
 
+	switch cfg.auth.mode {
+	case authModeStateful:
+	case authModeStateless:
+		// HS256 keys shorter than the hash output weaken the signature
+		if len(cfg.auth.signingKey) < 32 {
+			logger.Fatal(errors.New("auth-signing-key must be at least 32 bytes in stateless mode"), nil)
+		}
+
+		if cfg.auth.accessTTL > maxStatelessAccessTTL {
+			logger.Fatal(fmt.Errorf("auth-access-ttl must be at most %s in stateless mode", maxStatelessAccessTTL), nil)
+		}
+	default:
+		logger.Fatal(fmt.Errorf("invalid auth-mode %q", cfg.auth.mode), nil)
+	}
+
+	// The limiter divides by the rate, a negative one would refill backwards
+	if cfg.limiter.Enabled && cfg.limiter.RequestsPerSecond <= 0 {
+		logger.Fatal(errors.New("limiter-rps must be positive"), nil)
+	}
+
+	if cfg.auth.basicLimiter.RequestsPerSecond <= 0 {
+		logger.Fatal(errors.New("auth-basic-limiter-rps must be positive"), nil)
+	}
+
+	// A zero interval panics in the worker's ticker, and a batch size below one never comes back short
+	if cfg.tokenCleanup.interval <= 0 {
+		logger.Fatal(errors.New("token-cleanup-interval must be positive"), nil)
+	}
+
+	if cfg.tokenCleanup.batchSize < 1 {
+		logger.Fatal(errors.New("token-cleanup-batch-size must be at least 1"), nil)
+	}
+
+	if cfg.lockout.window <= 0 {
+		logger.Fatal(errors.New("lockout-window must be positive"), nil)
+	}
+
+	if cfg.accountCleanup.interval <= 0 {
+		logger.Fatal(errors.New("account-cleanup-interval must be positive"), nil)
+	}
+
+	// Cursors then stop working on restart, and differ between instances
+	if cfg.cursorKey == "" {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			logger.Fatal(err, nil)
+		}
+		cfg.cursorKey = string(key)
+		logger.Info("no cursor-signing-key set, using a random key", nil)
+	}
+
+	// Unlike the cursor key it cannot be random, the chain written before a restart must still verify
+	if len(cfg.auditKey) < 32 {
+		logger.Fatal(errors.New("audit-signing-key must be at least 32 bytes"), nil)
+	}
+
+	if cfg.auditAnchorInterval <= 0 {
+		logger.Fatal(errors.New("audit-anchor-interval must be positive"), nil)
+	}
+
+	switch cfg.registration.mode {
+	case registrationModeOpen, registrationModeInvite, registrationModeClosed:
+	default:
+		logger.Fatal(fmt.Errorf("invalid registration-mode %q", cfg.registration.mode), nil)
+	}
+
+	hasher, err := newPasswordHasher(cfg.password)
+	if err != nil {
+		logger.Fatal(err, nil)
+	}
+
+	policy, err := newPasswordPolicy(cfg.password.policy)
+	if err != nil {
+		logger.Fatal(err, nil)
+	}
+
 	db, err := openDB(cfg)
 	if err != nil {
 		logger.Fatal(err, nil)
@@ -91,7 +212,7 @@ func main() {
 		debug:  *debug,
 		config: cfg,
 		logger: logger,
-		models: data.NewModels(db),
+		models: data.NewModels(db, hasher, policy, []byte(cfg.auditKey)),
 		mailer: mailer.New(cfg.smtp.Host, cfg.smtp.Port, cfg.smtp.Username, cfg.smtp.Password, cfg.smtp.Sender),
 		// cache:  cache.New(logger),
 	}
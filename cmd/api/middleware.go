@@ -6,7 +6,6 @@ import (
 	"net"
 	"net/http"
 	"runtime/debug"
-	"strings"
 	"sync"
 	"time"
 
@@ -46,17 +45,23 @@ func (app *application) recoverPanic(next http.Handler) http.Handler { // Return
 	})
 }
 
-func (app *application) rateLimit(next http.Handler) http.Handler {
+type client struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
 
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
-	}
+// Rate limiters keyed by client IP, shared by the global limiter and the Basic authentication one
+type clientLimiters struct {
+	mu      sync.Mutex // Only needed for the map operations
+	cfg     rate.LimiterConfig
+	clients map[string]*client
+}
 
-	var (
-		mu      sync.Mutex // Only needed for the map operations
-		clients = make(map[string]*client)
-	)
+func newClientLimiters(cfg rate.LimiterConfig) *clientLimiters {
+	l := &clientLimiters{
+		cfg:     cfg,
+		clients: make(map[string]*client),
+	}
 
 	// Background goroutine to delete not-seen-recently clients
 	go func() {
@@ -64,53 +69,69 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 			time.Sleep(time.Minute)
 
 			// Lock to prevent check limiter check while the cleaning is taking place
-			mu.Lock()
+			l.mu.Lock()
 
-			for ip, client := range clients {
+			for ip, client := range l.clients {
 				// Remove clients if they are not seen more than 3 mins
 				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
+					delete(l.clients, ip)
 				}
 			}
 
-			mu.Unlock()
+			l.mu.Unlock()
 		}
 	}() // Immediate function call operator
 
+	return l
+}
+
+// Report whether the client identified by the request's remote address may proceed
+func (l *clientLimiters) allow(r *http.Request) (bool, error) {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false, err
+	}
+
+	// Prevent this code from being executed concurrently
+	// Deferring is fine here, the lock is released before the next handler runs
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Init rate limit for specific
+	if _, found := l.clients[ip]; !found {
+		l.clients[ip] = &client{limiter: rate.New(l.cfg)}
+	}
+
+	l.clients[ip].lastSeen = time.Now()
+
+	return l.clients[ip].limiter.Allow(), nil
+}
+
+func (app *application) rateLimit(next http.Handler) http.Handler {
+	limiters := newClientLimiters(app.config.limiter)
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if app.config.limiter.Enabled {
-			ip, _, err := net.SplitHostPort(r.RemoteAddr)
-
+			allowed, err := limiters.allow(r)
 			if err != nil {
 				app.serverErrorResponse(w, r, err)
 				return
 			}
 
-			// Prevent this code from being executed concurrently
-			mu.Lock()
-
-			// Init rate limit for specific
-			if _, found := clients[ip]; !found {
-				clients[ip] = &client{limiter: rate.New(app.config.limiter)}
-			}
-
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
+			if !allowed {
 				app.rateLimitExceedResponse(w, r)
 				return
 			}
-
-			// IMPORTANT: Unlock the mutex before calling the next handler
-			// No defer here - If we do so, the mutex is still locked until the entire handler chain completes aka we complete processing the HTTP request
-			mu.Unlock()
 		}
 
 		next.ServeHTTP(w, r)
-
 	})
 }
 
 func (app *application) authenticate(next http.Handler) http.Handler {
+	// Every Basic request runs bcrypt, so it gets its own much lower budget
+	basicLimiters := newClientLimiters(app.config.auth.basicLimiter)
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Vary", "Authorization")
 
@@ -122,13 +143,64 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		headerParts := strings.Split(authorizationHeader, " ")
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		scheme, token, ok := app.readAuthorizationHeader(r)
+		if !ok {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		// Long-lived keys used by service-to-service clients
+		if scheme == "ApiKey" {
+			app.authenticateAPIKey(w, r, next, token)
+			return
+		}
+
+		// Email and password on every request, for scripts and curl
+		if scheme == "Basic" && app.config.auth.basicEnabled {
+			allowed, err := basicLimiters.allow(r)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			if !allowed {
+				app.rateLimitExceedResponse(w, r)
+				return
+			}
+
+			app.authenticateBasic(w, r, next)
+			return
+		}
+
+		if scheme != "Bearer" {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
 
-		token := headerParts[1]
+		if app.config.auth.mode == authModeStateless {
+			user, permissions, family, err := app.parseStatelessToken(token)
+			if err == nil {
+				// Off by default, a revoked login then keeps working until the short-lived token expires
+				// Refreshing it is refused either way, as the refresh token is gone
+				if app.config.auth.revocationCheck {
+					active, err := app.models.Token.FamilyActive(family, user.ID)
+					if err != nil {
+						app.serverErrorResponse(w, r, err)
+						return
+					}
+
+					if !active {
+						app.invalidAuthenticationTokenResponse(w, r)
+						return
+					}
+				}
+
+				r = app.contextSetUser(r, user)
+				r = app.contextSetPermissions(r, permissions)
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
 
 		v := validator.New()
 
@@ -137,23 +209,96 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		// OAuth access tokens only ever live in the database, so they are looked up whatever the fallback says
+		if app.config.auth.mode == authModeStateless && !app.config.auth.statefulFallback {
+			app.authenticateOAuthToken(w, r, next, token)
+			return
+		}
+
 		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
-				app.invalidAuthenticationTokenResponse(w, r)
+				// Not one of our own tokens, it may have been issued to an OAuth client
+				app.authenticateOAuthToken(w, r, next, token)
 			default:
 				app.serverErrorResponse(w, r, err)
 			}
 			return
 		}
 
+		// Feeds the session list, a failure here should not fail the request
+		err = app.models.Token.Touch(data.HashTokenPlaintext(token), app.clientIP(r))
+		if err != nil {
+			app.logError(r, err)
+		}
+
 		r = app.contextSetUser(r, user)
 
 		next.ServeHTTP(w, r)
 	})
 }
 
+// Verify the email and password sent with the request, behind the same lockout as the login endpoint
+func (app *application) authenticateBasic(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	email, password, ok := r.BasicAuth()
+	if !ok {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	// Basic has nowhere to carry a second factor, so those accounts fail here and must log in for a token
+	result := app.checkLogin(email, password, "")
+
+	if err := result.err; err != nil {
+		switch {
+		case errors.Is(err, errAccountLocked):
+			app.accountLockedResponse(w, r, result.retryAfter)
+		case errors.Is(err, data.ErrRecordNotFound), errors.Is(err, errInvalidCredentials), errors.Is(err, errInvalidOTP):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	r = app.contextSetUser(r, result.user)
+
+	next.ServeHTTP(w, r)
+}
+
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, key string) {
+	v := validator.New()
+
+	if data.ValidateAPIKeyPlaintext(v, key); !v.Valid() {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	user, scoped, err := app.models.APIKeys.GetForKey(key)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Permissions revoked from the owner after the key was created must not survive on the key
+	granted, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetPermissions(r, scoped.Intersect(granted))
+
+	next.ServeHTTP(w, r)
+}
+
 func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
 	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
@@ -184,3 +329,43 @@ func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.Han
 	})
 
 }
+
+// What the request may grant onwards: the user's permissions, narrowed to those carried by the token or API key
+// Anything minting a new credential must stay within these, or a scoped credential could escalate to the owner's full set
+func (app *application) effectivePermissions(r *http.Request) (data.Permissions, error) {
+	granted, err := app.models.Permissions.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if scoped, ok := app.contextGetPermissions(r); ok {
+		return scoped.Intersect(granted), nil
+	}
+
+	return granted, nil
+}
+
+func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		permissions, ok := app.contextGetPermissions(r)
+		if !ok {
+			var err error
+
+			permissions, err = app.models.Permissions.GetAllForUser(app.contextGetUser(r).ID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		if !permissions.Include(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	// Check activation status and authentication BEFORE permissions
+	return app.requireActivatedUser(fn)
+}
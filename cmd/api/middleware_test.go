@@ -0,0 +1,239 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"greenlight.honganhpham.net/internal/assert"
+	"greenlight.honganhpham.net/internal/data"
+	"greenlight.honganhpham.net/internal/mocks"
+	"greenlight.honganhpham.net/internal/rate"
+)
+
+func TestRequirePermission(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+
+	tests := []struct {
+		name           string
+		user           *data.User
+		expectedStatus int
+	}{
+		{
+			name:           "Anonymous User",
+			user:           data.AnonymousUser,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Inactive User",
+			user:           &data.User{ID: 1, Activated: false},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Activated User Without Permissions",
+			user:           &data.User{ID: 99, Activated: true},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Activated User With Permissions",
+			user:           mocks.ActivatedUser,
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newAuthenticatedTestServer(t, app, tt.user)
+			defer ts.Close()
+
+			code, _, _ := ts.get(t, MovieV1+"/1")
+			assert.Equal(t, code, tt.expectedStatus)
+		})
+	}
+}
+
+func TestAuthenticateStatelessToken(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+	app.config.auth = AuthConfig{mode: authModeStateless, signingKey: "0123456789abcdef0123456789abcdef", revocationCheck: true}
+
+	ts := newTestServer(t, app.authenticate(app))
+	defer ts.Close()
+
+	reader := &data.User{ID: 99, Activated: true}
+
+	// A live refresh token keeps the login, and with it the signed tokens, valid
+	family, err := data.NewTokenFamily()
+	assert.NilError(t, err)
+
+	_, err = app.models.Token.NewInFamily(reader.ID, time.Hour, data.ScopeRefresh, family, data.SessionInfo{})
+	assert.NilError(t, err)
+
+	revokedFamily, err := data.NewTokenFamily()
+	assert.NilError(t, err)
+
+	readToken, _, err := app.newStatelessToken(reader, data.Permissions{data.PermissionMoviesRead}, family, time.Hour)
+	assert.NilError(t, err)
+
+	expiredToken, _, err := app.newStatelessToken(reader, data.Permissions{data.PermissionMoviesRead}, family, -time.Hour)
+	assert.NilError(t, err)
+
+	revokedToken, _, err := app.newStatelessToken(reader, data.Permissions{data.PermissionMoviesRead}, revokedFamily, time.Hour)
+	assert.NilError(t, err)
+
+	noFamilyToken, _, err := app.newStatelessToken(reader, data.Permissions{data.PermissionMoviesRead}, nil, time.Hour)
+	assert.NilError(t, err)
+
+	tests := []struct {
+		name           string
+		method         string
+		token          string
+		expectedStatus int
+	}{
+		{
+			name:           "Permission From Token",
+			method:         http.MethodGet,
+			token:          readToken,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Missing Permission In Token",
+			method:         http.MethodDelete,
+			token:          readToken,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Expired Token",
+			method:         http.MethodGet,
+			token:          expiredToken,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Revoked Login",
+			method:         http.MethodGet,
+			token:          revokedToken,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Token Without Family",
+			method:         http.MethodGet,
+			token:          noFamilyToken,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Database Token Without Fallback",
+			method:         http.MethodGet,
+			token:          "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU",
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+MovieV1+"/1", nil)
+			assert.NilError(t, err)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+
+			rs, err := ts.Client().Do(req)
+			assert.NilError(t, err)
+			rs.Body.Close()
+
+			assert.Equal(t, rs.StatusCode, tt.expectedStatus)
+		})
+	}
+
+	// Without the check the signature alone is trusted, until the token expires
+	app.config.auth.revocationCheck = false
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+MovieV1+"/1", nil)
+	assert.NilError(t, err)
+	req.Header.Set("Authorization", "Bearer "+revokedToken)
+
+	rs, err := ts.Client().Do(req)
+	assert.NilError(t, err)
+	rs.Body.Close()
+
+	assert.Equal(t, rs.StatusCode, http.StatusOK)
+}
+
+func TestAuthenticateBasic(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+	app.config.auth.basicLimiter = rate.LimiterConfig{RequestsPerSecond: 1, BurstSize: 10}
+
+	ts := newTestServer(t, app.authenticate(app))
+	defer ts.Close()
+
+	request := func(email, password string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+MovieV1+"/1", nil)
+		assert.NilError(t, err)
+		req.SetBasicAuth(email, password)
+
+		rs, err := ts.Client().Do(req)
+		assert.NilError(t, err)
+		rs.Body.Close()
+
+		return rs
+	}
+
+	// Off by default, and only Bearer is offered in the challenge
+	rs := request(mocks.ActivatedUser.Email, mocks.MockPassword)
+	assert.Equal(t, rs.StatusCode, http.StatusUnauthorized)
+	assert.Equal(t, len(rs.Header.Values("WWW-Authenticate")), 1)
+
+	app.config.auth.basicEnabled = true
+
+	rs = request(mocks.ActivatedUser.Email, mocks.MockPassword)
+	assert.Equal(t, rs.StatusCode, http.StatusOK)
+
+	rs = request(mocks.ActivatedUser.Email, "wrongpassword")
+	assert.Equal(t, rs.StatusCode, http.StatusUnauthorized)
+
+	challenges := strings.Join(rs.Header.Values("WWW-Authenticate"), ", ")
+	assert.StringContains(t, challenges, "Bearer")
+	assert.StringContains(t, challenges, "Basic")
+
+	// Answered the same way as a wrong password, after the same amount of hashing
+	rs = request("unknown@example.com", mocks.MockPassword)
+	assert.Equal(t, rs.StatusCode, http.StatusUnauthorized)
+
+	// Each request runs bcrypt, so the budget is spent quickly
+	// The refill is negligible, as bcrypt alone can take over a second under the race detector
+	app.config.auth.basicLimiter = rate.LimiterConfig{RequestsPerSecond: 0.001, BurstSize: 1}
+
+	limited := newTestServer(t, app.authenticate(app))
+	defer limited.Close()
+
+	statuses := make([]int, 0, 2)
+	for range 2 {
+		req, err := http.NewRequest(http.MethodGet, limited.URL+MovieV1+"/1", nil)
+		assert.NilError(t, err)
+		req.SetBasicAuth(mocks.ActivatedUser.Email, mocks.MockPassword)
+
+		rs, err := limited.Client().Do(req)
+		assert.NilError(t, err)
+		rs.Body.Close()
+
+		statuses = append(statuses, rs.StatusCode)
+	}
+
+	assert.Equal(t, statuses[0], http.StatusOK)
+	assert.Equal(t, statuses[1], http.StatusTooManyRequests)
+}
@@ -55,10 +55,11 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 
 	// Copy data to a new Movie struct so as to take advantage of validation function
 	movie := &data.Movie{
-		Title:   input.Title,
-		Year:    input.Year,
-		Runtime: input.Runtime,
-		Genres:  input.Genres,
+		Title:     input.Title,
+		Year:      input.Year,
+		Runtime:   input.Runtime,
+		Genres:    input.Genres,
+		CreatedBy: app.contextGetUser(r).ID, // Lets the author's data export include the movie
 	}
 
 	// In a complex system, we might need multiple validation helpers
@@ -77,6 +78,8 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	app.audit(r, &data.AuditEvent{ActorID: movie.CreatedBy, Action: data.AuditMovieCreated, TargetType: "movie", TargetID: movie.ID})
+
 	// Help client identify the URL the newly created resource is at
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf(MovieV1+"/%d", movie.ID))
@@ -164,6 +167,8 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	app.audit(r, &data.AuditEvent{ActorID: app.contextGetUser(r).ID, Action: data.AuditMovieUpdated, TargetType: "movie", TargetID: movie.ID})
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
 
 	if err != nil {
@@ -191,6 +196,8 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	app.audit(r, &data.AuditEvent{ActorID: app.contextGetUser(r).ID, Action: data.AuditMovieDeleted, TargetType: "movie", TargetID: id})
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted!"}, nil)
 
 	if err != nil {
@@ -211,6 +218,25 @@ func (app *application) listMovieHandler(w http.ResponseWriter, r *http.Request)
 
 	input.Title = app.readString(qs, "title", "")
 	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.Filters.GenresMode = app.readString(qs, "genres_mode", data.GenresModeAll)
+	input.Filters.Year = app.readIntRange(qs, "year", v)
+	input.Filters.Runtime = app.readIntRange(qs, "runtime", v)
+	input.Filters.Created = data.TimeRange{
+		After:  app.readTime(qs, "created_after", v),
+		Before: app.readTime(qs, "created_before", v),
+	}
+
+	input.Filters.FilterFields = data.FilterFields{
+		"title":      {Column: "title", Type: data.FilterText},
+		"year":       {Column: "year", Type: data.FilterInt},
+		"runtime":    {Column: "runtime", Type: data.FilterInt},
+		"genres":     {Column: "genres", Type: data.FilterTags},
+		"created_at": {Column: "created_at", Type: data.FilterTime},
+	}
+
+	if filter := app.readString(qs, "filter", ""); filter != "" {
+		input.Filters.Expression = data.ParseFilter(v, filter, input.Filters.FilterFields)
+	}
 
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 
@@ -218,6 +244,11 @@ func (app *application) listMovieHandler(w http.ResponseWriter, r *http.Request)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
 	input.Filters.SortSafeList = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
 
+	// Any cursor parameter switches to keyset pagination, an empty one asks for the first page
+	if qs.Has("cursor") {
+		app.readCursor(qs, &input.Filters, v)
+	}
+
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -236,3 +267,41 @@ func (app *application) listMovieHandler(w http.ResponseWriter, r *http.Request)
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+func (app *application) searchMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.MovieSearch
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Query = app.readString(qs, "q", "")
+	input.Config = app.readString(qs, "config", "english")
+	input.Prefix = app.readBool(qs, "prefix", false, v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-rank") // Best match first
+	input.Filters.SortSafeList = []string{"-rank", "id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+
+	data.ValidateMovieSearch(v, input.MovieSearch)
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := app.models.Movies.Search(input.MovieSearch, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
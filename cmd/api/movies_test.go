@@ -2,9 +2,12 @@ package main
 
 import (
 	"net/http"
+	"net/url"
 	"testing"
 
 	"greenlight.honganhpham.net/internal/assert"
+	"greenlight.honganhpham.net/internal/data"
+	"greenlight.honganhpham.net/internal/mocks"
 )
 
 func TestCreateMovieHandler(t *testing.T) {
@@ -17,7 +20,7 @@ func TestCreateMovieHandler(t *testing.T) {
 	})
 
 	app := newTestApplication(t, tl)
-	ts := newTestServer(t, app)
+	ts := newAuthenticatedTestServer(t, app, mocks.ActivatedUser)
 
 	defer ts.Close()
 
@@ -139,7 +142,7 @@ func TestShowMovieHandler(t *testing.T) {
 	})
 
 	app := newTestApplication(t, tl)
-	ts := newTestServer(t, app)
+	ts := newAuthenticatedTestServer(t, app, mocks.ActivatedUser)
 
 	defer ts.Close()
 
@@ -197,7 +200,7 @@ func TestUpdateMovieHandler(t *testing.T) {
 	})
 
 	app := newTestApplication(t, tl)
-	ts := newTestServer(t, app)
+	ts := newAuthenticatedTestServer(t, app, mocks.ActivatedUser)
 
 	defer ts.Close()
 
@@ -283,6 +286,99 @@ func TestUpdateMovieHandler(t *testing.T) {
 	}
 }
 
+func TestListMovieHandler(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+
+	ts := newAuthenticatedTestServer(t, app, mocks.ActivatedUser)
+	defer ts.Close()
+
+	key := []byte(app.config.cursorKey)
+	cursor := data.Cursor{Sort: "-year", Values: []string{"2018"}, ID: 7}.Encode(key)
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+	}{
+		{"Page Mode", "?page=2&page_size=10", http.StatusOK},
+		{"First Cursor Page", "?cursor=&sort=-year", http.StatusOK},
+		{"Next Cursor Page", "?cursor=" + cursor, http.StatusOK},
+		{"Same Sort As Cursor", "?cursor=" + cursor + "&sort=-year", http.StatusOK},
+		{"Different Sort From Cursor", "?cursor=" + cursor + "&sort=title", http.StatusUnprocessableEntity},
+		{"Tampered Cursor", "?cursor=" + cursor[:len(cursor)-3] + "abc", http.StatusUnprocessableEntity},
+		{"Signed With Another Key", "?cursor=" + data.Cursor{Sort: "id", ID: 7}.Encode([]byte("other")), http.StatusUnprocessableEntity},
+		{"Cursor With Page", "?cursor=&page=2", http.StatusUnprocessableEntity},
+		{"Page Size Too Large", "?page_size=101", http.StatusUnprocessableEntity},
+		{"Several Sort Columns", "?sort=-year,title,runtime", http.StatusOK},
+		{"Several Sort Columns With Cursor", "?cursor=&sort=-year,title", http.StatusOK},
+		{"Duplicate Sort Column", "?sort=year,-year", http.StatusUnprocessableEntity},
+		{"Invalid Sort Column", "?sort=year,rating", http.StatusUnprocessableEntity},
+		{"Ranges", "?year_min=1990&year_max=2000&runtime_min=90&created_after=2024-01-01T00:00:00Z", http.StatusOK},
+		{"Any Genre", "?genres=drama,comedy&genres_mode=any", http.StatusOK},
+		{"Filter Expression", "?filter=" + url.QueryEscape(`year>=1990 and genres has "drama" and not title~"part"`), http.StatusOK},
+		{"Filter Unknown Field", "?filter=" + url.QueryEscape(`rating > 5`), http.StatusUnprocessableEntity},
+		{"Filter Syntax Error", "?filter=" + url.QueryEscape(`(year = 1`), http.StatusUnprocessableEntity},
+		{"Filter Integer Overflow", "?filter=" + url.QueryEscape(`year>99999999999`), http.StatusUnprocessableEntity},
+		{"Unknown Genres Mode", "?genres=drama&genres_mode=some", http.StatusUnprocessableEntity},
+		{"Inverted Year Range", "?year_min=2000&year_max=1990", http.StatusUnprocessableEntity},
+		{"Negative Runtime", "?runtime_min=-1", http.StatusUnprocessableEntity},
+		{"Non-numeric Year", "?year_max=soon", http.StatusUnprocessableEntity},
+		{"Inverted Created Range", "?created_after=2024-02-01T00:00:00Z&created_before=2024-01-01T00:00:00Z", http.StatusUnprocessableEntity},
+		{"Invalid Created Time", "?created_after=yesterday", http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, _ := ts.get(t, MovieV1+tt.query)
+			assert.Equal(t, code, tt.expectedStatus)
+		})
+	}
+}
+
+func TestSearchMoviesHandler(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+
+	ts := newAuthenticatedTestServer(t, app, mocks.ActivatedUser)
+	defer ts.Close()
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"Match", "?q=movie", http.StatusOK, `"rank": 0.1`},
+		{"No Match", "?q=panther", http.StatusOK, `"movies": []`},
+		{"Prefix", "?q=mov&prefix=true&config=simple", http.StatusOK, `"total_records": 1`},
+		{"Sorted By Title", "?q=movie&sort=title,-year", http.StatusOK, `"total_records": 1`},
+		{"Missing Query", "", http.StatusUnprocessableEntity, `"q": "must be provided"`},
+		{"Unknown Config", "?q=movie&config=french", http.StatusUnprocessableEntity, `"config": "must be english or simple"`},
+		{"Invalid Prefix", "?q=movie&prefix=maybe", http.StatusUnprocessableEntity, `"prefix": "must be a boolean value"`},
+		{"Prefix Without Words", "?q=" + url.QueryEscape(`"-"`) + "&prefix=1", http.StatusUnprocessableEntity, `"q": "must contain at least one word"`},
+		{"Invalid Sort", "?q=movie&sort=rank", http.StatusUnprocessableEntity, `"sort": "invalid sort value"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, MovieV1+"/search"+tt.query)
+			assert.Equal(t, code, tt.expectedStatus)
+			assert.StringContains(t, body, tt.expectedBody)
+		})
+	}
+}
+
 func TestDeleteMovieHandler(t *testing.T) {
 	tl := newTestLogger(t)
 
@@ -293,7 +389,7 @@ func TestDeleteMovieHandler(t *testing.T) {
 
 	app := newTestApplication(t, tl)
 
-	ts := newTestServer(t, app)
+	ts := newAuthenticatedTestServer(t, app, mocks.ActivatedUser)
 
 	defer ts.Close()
 	tests := []struct {
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"greenlight.honganhpham.net/internal/data"
+	"greenlight.honganhpham.net/internal/validator"
+)
+
+// Authorization codes are exchanged right after the redirect, so they can be short-lived
+const oauthCodeTTL = 10 * time.Minute
+
+var errInvalidClient = errors.New("invalid client")
+
+func (app *application) registerOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+		Confidential bool     `json:"confidential"` // Servers that can keep a secret, needed for client credentials
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	// Capped by the credential making the request, not only by what the user holds
+	granted, err := app.effectivePermissions(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	client := &data.OAuthClient{
+		UserID:       user.ID,
+		Name:         input.Name,
+		RedirectURIs: input.RedirectURIs,
+		Scopes:       input.Scopes,
+	}
+
+	if client.RedirectURIs == nil {
+		client.RedirectURIs = []string{}
+	}
+
+	v := validator.New()
+
+	// Without a secret the authorization code flow is the only way to get a token
+	v.Check(input.Confidential || len(client.RedirectURIs) > 0, "redirect_uris", "must be provided for public clients")
+
+	if data.ValidateOAuthClient(v, client, granted); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.OAuth.NewClient(client, input.Confidential)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The secret is only ever shown in this response
+	err = app.writeJSON(w, http.StatusCreated, envelope{"client": client}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Authorization endpoint of RFC 6749 section 4.1.1, the authenticated request itself is the user's consent
+func (app *application) authorizeOAuthHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	client, err := app.models.OAuth.GetClient(qs.Get("client_id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("client_id", "unknown client")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Never redirect to an unregistered URI, or the code could be sent to an attacker
+	redirectURI := qs.Get("redirect_uri")
+	if !client.HasRedirectURI(redirectURI) {
+		v.AddError("redirect_uri", "does not match a registered redirect URI")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// From here on errors are reported back to the client through the redirect
+	redirect := func(params url.Values) {
+		u, _ := url.Parse(redirectURI)
+
+		query := u.Query()
+		for key := range params {
+			query.Set(key, params.Get(key))
+		}
+		if state := qs.Get("state"); state != "" {
+			query.Set("state", state)
+		}
+
+		u.RawQuery = query.Encode()
+		http.Redirect(w, r, u.String(), http.StatusFound)
+	}
+
+	redirectError := func(code, description string) {
+		redirect(url.Values{"error": {code}, "error_description": {description}})
+	}
+
+	if qs.Get("response_type") != "code" {
+		redirectError("unsupported_response_type", "only the code response type is supported")
+		return
+	}
+
+	// PKCE is required from every client, the plain method offers no protection
+	if qs.Get("code_challenge") == "" || qs.Get("code_challenge_method") != "S256" {
+		redirectError("invalid_request", "a code_challenge using the S256 method is required")
+		return
+	}
+
+	scopes, ok := app.oauthScopes(client, qs.Get("scope"))
+	if !ok {
+		redirectError("invalid_scope", "the client is not allowed to request these scopes")
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	// Capped by the credential making the request, not only by what the user holds
+	granted, err := app.effectivePermissions(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The user cannot hand out permissions they do not hold
+	scopes = scopes.Intersect(granted)
+	if len(scopes) == 0 {
+		redirectError("access_denied", "you do not hold any of the requested permissions")
+		return
+	}
+
+	code := &data.OAuthCode{
+		ClientID:      client.ID,
+		UserID:        user.ID,
+		RedirectURI:   redirectURI,
+		Scopes:        scopes,
+		CodeChallenge: qs.Get("code_challenge"),
+	}
+
+	err = app.models.OAuth.NewCode(code, oauthCodeTTL)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	redirect(url.Values{"code": {code.Plaintext}})
+}
+
+// Token endpoint of RFC 6749 section 3.2, which takes form parameters instead of JSON
+func (app *application) createOAuthTokenHandler(w http.ResponseWriter, r *http.Request) {
+	form, err := app.readForm(w, r)
+	if err != nil {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	client, err := app.authenticateOAuthClient(form)
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidClient):
+			app.oauthErrorResponse(w, r, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var userID int64
+	var scopes data.Permissions
+
+	switch form.Get("grant_type") {
+	case "authorization_code":
+		code, err := app.models.OAuth.ConsumeCode(form.Get("code"))
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "invalid or expired authorization code")
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if code.ClientID != client.ID || code.RedirectURI != form.Get("redirect_uri") {
+			app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "the code was issued to another client or redirect URI")
+			return
+		}
+
+		if !data.VerifyCodeChallenge(form.Get("code_verifier"), code.CodeChallenge) {
+			app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "invalid code_verifier")
+			return
+		}
+
+		userID, scopes = code.UserID, code.Scopes
+
+	case "client_credentials":
+		// The client acts on its own behalf, i.e. as the user who registered it
+		if !client.Confidential() {
+			app.oauthErrorResponse(w, r, http.StatusBadRequest, "unauthorized_client", "public clients cannot use the client_credentials grant")
+			return
+		}
+
+		var ok bool
+		scopes, ok = app.oauthScopes(client, form.Get("scope"))
+		if !ok {
+			app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_scope", "the client is not allowed to request these scopes")
+			return
+		}
+
+		userID = client.UserID
+
+	default:
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "unsupported_grant_type", "only authorization_code and client_credentials are supported")
+		return
+	}
+
+	token, err := app.models.OAuth.NewAccessToken(userID, client.ID, scopes, app.config.auth.accessTTL)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, &data.AuditEvent{
+		ActorID:  userID,
+		Action:   data.AuditTokenCreated,
+		Metadata: map[string]string{"grant": form.Get("grant_type"), "client_id": client.ClientID},
+	})
+
+	// Token responses must never be cached, RFC 6749 section 5.1
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "no-store")
+	headers.Set("Pragma", "no-cache")
+
+	err = app.writeJSON(w, http.StatusOK, envelope{
+		"access_token": token.Plaintext,
+		"token_type":   "Bearer",
+		"expires_in":   int(time.Until(token.Expiry).Seconds()),
+		"scope":        strings.Join(scopes, " "),
+	}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Introspection endpoint of RFC 7662, restricted to confidential clients
+func (app *application) introspectOAuthTokenHandler(w http.ResponseWriter, r *http.Request) {
+	form, err := app.readForm(w, r)
+	if err != nil {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	client, err := app.authenticateOAuthClient(form)
+	if err == nil && !client.Confidential() {
+		err = errInvalidClient
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidClient):
+			app.oauthErrorResponse(w, r, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	inactive := envelope{"active": false}
+
+	access, err := app.models.OAuth.GetAccess(form.Get("token"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeJSON(w, http.StatusOK, inactive, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Report what the token can actually do today, like authenticate() does
+	granted, err := app.models.Permissions.GetAllForUser(access.UserID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{
+		"active":     true,
+		"scope":      strings.Join(access.Scopes.Intersect(granted), " "),
+		"client_id":  access.ClientID,
+		"sub":        strconv.FormatInt(access.UserID, 10),
+		"exp":        access.Expiry.Unix(),
+		"token_type": "Bearer",
+	}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Authenticate the client from the client_id and client_secret form parameters
+// Public clients only identify themselves, confidential ones must present their secret
+func (app *application) authenticateOAuthClient(form url.Values) (*data.OAuthClient, error) {
+	client, err := app.models.OAuth.GetClient(form.Get("client_id"))
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, errInvalidClient
+		}
+		return nil, err
+	}
+
+	if client.Confidential() && !client.SecretMatches(form.Get("client_secret")) {
+		return nil, errInvalidClient
+	}
+
+	return client, nil
+}
+
+// Parse the requested scopes, defaulting to everything the client registered for
+// Reports false if the client asks for a scope it was not registered with
+func (app *application) oauthScopes(client *data.OAuthClient, scope string) (data.Permissions, bool) {
+	requested := data.ParseOAuthScopes(scope)
+	if len(requested) == 0 {
+		return client.Scopes, true
+	}
+
+	for _, code := range requested {
+		if !client.Scopes.Include(code) {
+			return nil, false
+		}
+	}
+
+	return requested, true
+}
+
+// Resolve an access token issued to an OAuth client, limited to its scopes
+func (app *application) authenticateOAuthToken(w http.ResponseWriter, r *http.Request, next http.Handler, token string) {
+	access, err := app.models.OAuth.GetAccess(token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.models.Users.Get(access.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Permissions revoked from the user after the grant must not survive on the token
+	granted, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetPermissions(r, access.Scopes.Intersect(granted))
+
+	next.ServeHTTP(w, r)
+}
+
+// Parse an application/x-www-form-urlencoded body with the same size limit as readJSON
+func (app *application) readForm(w http.ResponseWriter, r *http.Request) (url.Values, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		return nil, fmt.Errorf("body must be application/x-www-form-urlencoded")
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.PostForm, nil
+}
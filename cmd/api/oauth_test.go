@@ -0,0 +1,360 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"greenlight.honganhpham.net/internal/assert"
+	"greenlight.honganhpham.net/internal/data"
+	"greenlight.honganhpham.net/internal/mocks"
+)
+
+func TestOAuthAuthorizationCodeFlow(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+
+	// The user registering the client and approving the request
+	userServer := newAuthenticatedTestServer(t, app, mocks.ActivatedUser)
+	defer userServer.Close()
+
+	// Inspect the redirect instead of following it
+	userServer.Client().CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	// The third-party client only talks to the public endpoints
+	ts := newTestServer(t, app.authenticate(app))
+	defer ts.Close()
+
+	redirectURI := "https://app.example.com/callback"
+
+	code, _, body := userServer.post(t, OAuthV1+"/clients", []byte(`{"name": "Movie app", "redirect_uris": ["`+redirectURI+`"], "scopes": ["movies:read"]}`))
+	assert.Equal(t, code, http.StatusCreated)
+
+	var registered struct {
+		Client struct {
+			ClientID     string `json:"client_id"`
+			ClientSecret string `json:"client_secret"`
+		} `json:"client"`
+	}
+	err := json.Unmarshal(body, &registered)
+	assert.NilError(t, err)
+
+	clientID := registered.Client.ClientID
+	assert.Equal(t, registered.Client.ClientSecret, "")
+
+	verifier := strings.Repeat("v", 43)
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	authorize := func(params url.Values) *http.Response {
+		rs, err := userServer.Client().Get(userServer.URL + OAuthV1 + "/authorize?" + params.Encode())
+		assert.NilError(t, err)
+		rs.Body.Close()
+		return rs
+	}
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"redirect_uri":          {redirectURI},
+		"state":                 {"xyz"},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+
+	// An unregistered redirect URI is refused outright rather than redirected to
+	bad := url.Values{}
+	for key := range params {
+		bad.Set(key, params.Get(key))
+	}
+	bad.Set("redirect_uri", "https://evil.example.com/callback")
+	assert.Equal(t, authorize(bad).StatusCode, http.StatusUnprocessableEntity)
+
+	bad.Set("redirect_uri", redirectURI)
+	bad.Set("scope", "movies:write")
+	rs := authorize(bad)
+	assert.Equal(t, rs.StatusCode, http.StatusFound)
+	assert.StringContains(t, rs.Header.Get("Location"), "error=invalid_scope")
+
+	rs = authorize(params)
+	assert.Equal(t, rs.StatusCode, http.StatusFound)
+
+	location, err := url.Parse(rs.Header.Get("Location"))
+	assert.NilError(t, err)
+	assert.Equal(t, location.Query().Get("state"), "xyz")
+
+	authCode := location.Query().Get("code")
+
+	exchange := func(verifier string) (int, map[string]any) {
+		rs, err := ts.Client().PostForm(ts.URL+OAuthV1+"/token", url.Values{
+			"grant_type":    {"authorization_code"},
+			"client_id":     {clientID},
+			"code":          {authCode},
+			"redirect_uri":  {redirectURI},
+			"code_verifier": {verifier},
+		})
+		assert.NilError(t, err)
+		defer rs.Body.Close()
+
+		var response map[string]any
+		err = json.NewDecoder(rs.Body).Decode(&response)
+		assert.NilError(t, err)
+
+		return rs.StatusCode, response
+	}
+
+	status, response := exchange(verifier)
+	assert.Equal(t, status, http.StatusOK)
+	assert.Equal(t, response["token_type"], "Bearer")
+	assert.Equal(t, response["scope"], data.PermissionMoviesRead)
+
+	accessToken := response["access_token"].(string)
+
+	// Codes are single use
+	status, response = exchange(verifier)
+	assert.Equal(t, status, http.StatusBadRequest)
+	assert.Equal(t, response["error"], "invalid_grant")
+
+	// The token can read movies but not change them
+	for method, expected := range map[string]int{http.MethodGet: http.StatusOK, http.MethodDelete: http.StatusForbidden} {
+		req, err := http.NewRequest(method, ts.URL+MovieV1+"/1", nil)
+		assert.NilError(t, err)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+
+		rs, err := ts.Client().Do(req)
+		assert.NilError(t, err)
+		rs.Body.Close()
+
+		assert.Equal(t, rs.StatusCode, expected)
+	}
+}
+
+func TestOAuthClientCredentialsAndIntrospection(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+
+	client := &data.OAuthClient{
+		UserID: mocks.ActivatedUser.ID,
+		Name:   "Importer",
+		Scopes: data.Permissions{data.PermissionMoviesRead, data.PermissionMoviesWrite},
+	}
+	err := app.models.OAuth.NewClient(client, true)
+	assert.NilError(t, err)
+
+	public := &data.OAuthClient{
+		UserID:       mocks.ActivatedUser.ID,
+		Name:         "Mobile",
+		RedirectURIs: []string{"https://app.example.com/callback"},
+		Scopes:       data.Permissions{data.PermissionMoviesRead},
+	}
+	err = app.models.OAuth.NewClient(public, false)
+	assert.NilError(t, err)
+
+	ts := newTestServer(t, app.authenticate(app))
+	defer ts.Close()
+
+	post := func(path string, form url.Values) (int, map[string]any) {
+		rs, err := ts.Client().PostForm(ts.URL+OAuthV1+path, form)
+		assert.NilError(t, err)
+		defer rs.Body.Close()
+
+		var response map[string]any
+		err = json.NewDecoder(rs.Body).Decode(&response)
+		assert.NilError(t, err)
+
+		return rs.StatusCode, response
+	}
+
+	status, response := post("/token", url.Values{"grant_type": {"client_credentials"}, "client_id": {client.ClientID}, "client_secret": {"wrong"}})
+	assert.Equal(t, status, http.StatusUnauthorized)
+	assert.Equal(t, response["error"], "invalid_client")
+
+	status, response = post("/token", url.Values{"grant_type": {"client_credentials"}, "client_id": {public.ClientID}})
+	assert.Equal(t, status, http.StatusBadRequest)
+	assert.Equal(t, response["error"], "unauthorized_client")
+
+	status, response = post("/token", url.Values{"grant_type": {"client_credentials"}, "client_id": {client.ClientID}, "client_secret": {client.Secret}, "scope": {"movies:read"}})
+	assert.Equal(t, status, http.StatusOK)
+	assert.Equal(t, response["scope"], data.PermissionMoviesRead)
+
+	accessToken := response["access_token"].(string)
+
+	tests := []struct {
+		name           string
+		form           url.Values
+		expectedStatus int
+		expectedActive bool
+	}{
+		{
+			name:           "Public Client",
+			form:           url.Values{"client_id": {public.ClientID}, "token": {accessToken}},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Active Token",
+			form:           url.Values{"client_id": {client.ClientID}, "client_secret": {client.Secret}, "token": {accessToken}},
+			expectedStatus: http.StatusOK,
+			expectedActive: true,
+		},
+		{
+			name:           "Unknown Token",
+			form:           url.Values{"client_id": {client.ClientID}, "client_secret": {client.Secret}, "token": {"UNKNOWNTOKEN00000000000000"}},
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, response := post("/introspect", tt.form)
+			assert.Equal(t, status, tt.expectedStatus)
+
+			if tt.expectedStatus == http.StatusOK {
+				assert.Equal(t, response["active"], any(tt.expectedActive))
+			}
+
+			if tt.expectedActive {
+				assert.Equal(t, response["client_id"], any(client.ClientID))
+				assert.Equal(t, response["sub"], "2")
+			}
+		})
+	}
+}
+
+func TestOAuthScopedCredential(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+
+	userServer := newAuthenticatedTestServer(t, app, mocks.ActivatedUser)
+	defer userServer.Close()
+
+	ts := newTestServer(t, app.authenticate(app))
+	defer ts.Close()
+
+	ts.Client().CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	// The owner holds movies:write, but this key was only given movies:read
+	key, err := app.models.APIKeys.New(mocks.ActivatedUser.ID, "reader", data.Permissions{data.PermissionMoviesRead})
+	assert.NilError(t, err)
+
+	do := func(method, path, body string) *http.Response {
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		assert.NilError(t, err)
+		req.Header.Set("Authorization", "ApiKey "+key.Plaintext)
+
+		rs, err := ts.Client().Do(req)
+		assert.NilError(t, err)
+		rs.Body.Close()
+		return rs
+	}
+
+	redirectURI := "https://app.example.com/callback"
+
+	t.Run("Register Broader Client", func(t *testing.T) {
+		rs := do(http.MethodPost, OAuthV1+"/clients", `{"name": "Movie app", "redirect_uris": ["`+redirectURI+`"], "scopes": ["movies:write"]}`)
+		assert.Equal(t, rs.StatusCode, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("Authorize Broader Scope", func(t *testing.T) {
+		// Registered through the full session, so the client itself may ask for movies:write
+		code, _, body := userServer.post(t, OAuthV1+"/clients", []byte(`{"name": "Movie app", "redirect_uris": ["`+redirectURI+`"], "scopes": ["movies:write"]}`))
+		assert.Equal(t, code, http.StatusCreated)
+
+		var registered struct {
+			Client struct {
+				ClientID string `json:"client_id"`
+			} `json:"client"`
+		}
+		err := json.Unmarshal(body, &registered)
+		assert.NilError(t, err)
+
+		params := url.Values{
+			"response_type":         {"code"},
+			"client_id":             {registered.Client.ClientID},
+			"redirect_uri":          {redirectURI},
+			"scope":                 {"movies:write"},
+			"code_challenge":        {strings.Repeat("c", 43)},
+			"code_challenge_method": {"S256"},
+		}
+
+		rs := do(http.MethodGet, OAuthV1+"/authorize?"+params.Encode(), "")
+		assert.Equal(t, rs.StatusCode, http.StatusFound)
+
+		location, err := url.Parse(rs.Header.Get("Location"))
+		assert.NilError(t, err)
+		assert.Equal(t, location.Query().Get("error"), "access_denied")
+		assert.Equal(t, location.Query().Get("code"), "")
+	})
+}
+
+func TestOAuthStatelessMode(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+	app.config.auth = AuthConfig{mode: authModeStateless, signingKey: "0123456789abcdef0123456789abcdef", accessTTL: 15 * time.Minute}
+
+	client := &data.OAuthClient{
+		UserID: mocks.ActivatedUser.ID,
+		Name:   "Importer",
+		Scopes: data.Permissions{data.PermissionMoviesRead},
+	}
+	err := app.models.OAuth.NewClient(client, true)
+	assert.NilError(t, err)
+
+	ts := newTestServer(t, app.authenticate(app))
+	defer ts.Close()
+
+	rs, err := ts.Client().PostForm(ts.URL+OAuthV1+"/token", url.Values{"grant_type": {"client_credentials"}, "client_id": {client.ClientID}, "client_secret": {client.Secret}})
+	assert.NilError(t, err)
+	defer rs.Body.Close()
+	assert.Equal(t, rs.StatusCode, http.StatusOK)
+
+	var response struct {
+		AccessToken string `json:"access_token"`
+	}
+	err = json.NewDecoder(rs.Body).Decode(&response)
+	assert.NilError(t, err)
+
+	get := func(token string) int {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+MovieV1+"/1", nil)
+		assert.NilError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		rs, err := ts.Client().Do(req)
+		assert.NilError(t, err)
+		rs.Body.Close()
+
+		return rs.StatusCode
+	}
+
+	// Not a signed token and no fallback, yet the server's own OAuth tokens still work
+	assert.Equal(t, get(response.AccessToken), http.StatusOK)
+	assert.Equal(t, get("UNKNOWNTOKEN00000000000000"), http.StatusUnauthorized)
+}
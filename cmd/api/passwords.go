@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"golang.org/x/crypto/bcrypt"
+	"greenlight.honganhpham.net/internal/data"
+)
+
+const (
+	passwordAlgorithmBcrypt   = "bcrypt"
+	passwordAlgorithmArgon2id = "argon2id"
+)
+
+// Upper bounds keeping a single hash to around a second and its memory in check, as every login and registration runs one
+const (
+	maxBcryptCost        = 15
+	maxArgon2Memory      = 1024 * 1024 // KiB
+	maxArgon2Iterations  = 10
+	maxArgon2Parallelism = 16
+)
+
+type PasswordConfig struct {
+	algorithm         string // Used for new hashes, existing ones are upgraded on login
+	bcryptCost        int
+	argon2Memory      int // KiB
+	argon2Iterations  int
+	argon2Parallelism int
+	policy            PasswordPolicyConfig
+}
+
+type PasswordPolicyConfig struct {
+	minLength      int
+	rejectCommon   bool
+	rejectPersonal bool
+	breachDir      string // Directory of SHA-1 prefix files, empty to skip the breach lookup
+}
+
+func newPasswordHasher(cfg PasswordConfig) (data.PasswordHasher, error) {
+	switch cfg.algorithm {
+	case passwordAlgorithmBcrypt:
+		if cfg.bcryptCost < bcrypt.MinCost || cfg.bcryptCost > maxBcryptCost {
+			return nil, fmt.Errorf("password-bcrypt-cost must be between %d and %d", bcrypt.MinCost, maxBcryptCost)
+		}
+		return data.BcryptHasher{Cost: cfg.bcryptCost}, nil
+	case passwordAlgorithmArgon2id:
+		if cfg.argon2Parallelism < 1 || cfg.argon2Parallelism > maxArgon2Parallelism {
+			return nil, fmt.Errorf("password-argon2-parallelism must be between 1 and %d", maxArgon2Parallelism)
+		}
+		if cfg.argon2Iterations < 1 || cfg.argon2Iterations > maxArgon2Iterations {
+			return nil, fmt.Errorf("password-argon2-iterations must be between 1 and %d", maxArgon2Iterations)
+		}
+		// argon2 needs at least 8KiB per thread
+		if cfg.argon2Memory < 8*cfg.argon2Parallelism || cfg.argon2Memory > maxArgon2Memory {
+			return nil, fmt.Errorf("password-argon2-memory must be at least 8KiB per thread and at most %dKiB", maxArgon2Memory)
+		}
+		return data.Argon2idHasher{
+			Memory:      uint32(cfg.argon2Memory),
+			Iterations:  uint32(cfg.argon2Iterations),
+			Parallelism: uint8(cfg.argon2Parallelism),
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid password-algorithm %q", cfg.algorithm)
+	}
+}
+
+// Upgrade a hash made with a legacy algorithm or a lower cost, now that we know the plaintext
+// Failures are only logged as the old hash still works
+func (app *application) rehashPassword(r *http.Request, user *data.User, plaintext string) {
+	if !user.Password.NeedsRehash(app.models.Hasher) {
+		return
+	}
+
+	err := user.Password.Set(app.models.Hasher, plaintext)
+	if err == nil {
+		err = app.models.Users.Update(user)
+	}
+
+	if err != nil {
+		app.logError(r, err)
+		return
+	}
+
+	app.logger.Info("password rehashed", map[string]string{"user_id": strconv.FormatInt(user.ID, 10)})
+}
+
+func newPasswordPolicy(cfg PasswordPolicyConfig) (data.PasswordPolicy, error) {
+	policy := data.PasswordPolicy{
+		MinLength:      cfg.minLength,
+		RejectCommon:   cfg.rejectCommon,
+		RejectPersonal: cfg.rejectPersonal,
+	}
+
+	// Passwords are always at least 8 and at most 72 bytes long
+	if cfg.minLength < 8 || cfg.minLength > 72 {
+		return policy, errors.New("password-min-length must be between 8 and 72")
+	}
+
+	if cfg.breachDir != "" {
+		info, err := os.Stat(cfg.breachDir)
+		if err != nil {
+			return policy, err
+		}
+
+		if !info.IsDir() {
+			return policy, fmt.Errorf("password-breach-dir %q is not a directory", cfg.breachDir)
+		}
+
+		policy.Breached = &data.BreachedPasswords{Dir: cfg.breachDir}
+	}
+
+	return policy, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"greenlight.honganhpham.net/internal/assert"
+)
+
+func TestNewPasswordHasher(t *testing.T) {
+	argon2id := func(memory, iterations, parallelism int) PasswordConfig {
+		return PasswordConfig{algorithm: passwordAlgorithmArgon2id, argon2Memory: memory, argon2Iterations: iterations, argon2Parallelism: parallelism}
+	}
+
+	tests := []struct {
+		name    string
+		cfg     PasswordConfig
+		wantErr bool
+	}{
+		{"Default Bcrypt", PasswordConfig{algorithm: passwordAlgorithmBcrypt, bcryptCost: 12}, false},
+		{"Bcrypt Cost Too High", PasswordConfig{algorithm: passwordAlgorithmBcrypt, bcryptCost: maxBcryptCost + 1}, true},
+		{"Default Argon2id", argon2id(64*1024, 3, 2), false},
+		{"Argon2id Memory Too High", argon2id(maxArgon2Memory+1, 3, 2), true},
+		{"Argon2id Memory Too Low", argon2id(8, 3, 2), true},
+		{"Argon2id Iterations Too High", argon2id(64*1024, maxArgon2Iterations+1, 2), true},
+		{"Argon2id Parallelism Too High", argon2id(64*1024, 3, maxArgon2Parallelism+1), true},
+		{"Unknown Algorithm", PasswordConfig{algorithm: "md5"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newPasswordHasher(tt.cfg)
+			assert.Equal(t, err != nil, tt.wantErr)
+		})
+	}
+}
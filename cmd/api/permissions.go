@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"greenlight.honganhpham.net/internal/data"
+	"greenlight.honganhpham.net/internal/validator"
+)
+
+func (app *application) showUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserForPermissions(w, r)
+	if !ok {
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user_id": user.ID, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) grantUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	app.changeUserPermissions(w, r, "grant", app.models.Permissions.AddForUser)
+}
+
+func (app *application) revokeUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	app.changeUserPermissions(w, r, "revoke", app.models.Permissions.RemoveForUser)
+}
+
+// Shared flow for granting and revoking: read the codes, validate them against the permissions table,
+// apply the change, write an audit entry and respond with the resulting permissions
+func (app *application) changeUserPermissions(w http.ResponseWriter, r *http.Request, action string, apply func(int64, ...string) error) {
+	user, ok := app.readUserForPermissions(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Permissions []string `json:"permissions"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	known, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidatePermissionCodes(v, input.Permissions, known); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = apply(user.ID, input.Permissions...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Audit trail of who changed which permissions for whom
+	app.logger.Info("user permissions changed", map[string]string{
+		"action":      action,
+		"actor_id":    strconv.FormatInt(app.contextGetUser(r).ID, 10),
+		"user_id":     strconv.FormatInt(user.ID, 10),
+		"permissions": strings.Join(input.Permissions, ","),
+	})
+
+	app.audit(r, &data.AuditEvent{
+		ActorID:    app.contextGetUser(r).ID,
+		Action:     data.AuditPermissionsChanged,
+		TargetType: "user",
+		TargetID:   user.ID,
+		Metadata:   map[string]string{"change": action, "permissions": strings.Join(input.Permissions, ",")},
+	})
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user_id": user.ID, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Look up the user referenced in the URL, writing the error response if it cannot be found
+func (app *application) readUserForPermissions(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"greenlight.honganhpham.net/internal/assert"
+	"greenlight.honganhpham.net/internal/data"
+	"greenlight.honganhpham.net/internal/mocks"
+)
+
+func TestShowUserPermissionsHandler(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+
+	tests := []struct {
+		name           string
+		user           *data.User
+		urlPath        string
+		expectedStatus int
+	}{
+		{
+			name:           "Admin User",
+			user:           mocks.AdminUser,
+			urlPath:        UserV1 + "/2/permissions",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Non-admin User",
+			user:           mocks.ActivatedUser,
+			urlPath:        UserV1 + "/2/permissions",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Non-existent User",
+			user:           mocks.AdminUser,
+			urlPath:        UserV1 + "/999/permissions",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newAuthenticatedTestServer(t, app, tt.user)
+			defer ts.Close()
+
+			code, _, _ := ts.get(t, tt.urlPath)
+			assert.Equal(t, code, tt.expectedStatus)
+		})
+	}
+}
+
+func TestGrantUserPermissionsHandler(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+	ts := newAuthenticatedTestServer(t, app, mocks.AdminUser)
+	defer ts.Close()
+
+	tests := []struct {
+		name           string
+		inputJSON      string
+		expectedStatus int
+	}{
+		{
+			name:           "Valid Permission",
+			inputJSON:      `{"permissions": ["admin:permissions"]}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Unknown Permission",
+			inputJSON:      `{"permissions": ["movies:delete"]}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Duplicate Permissions",
+			inputJSON:      `{"permissions": ["movies:read", "movies:read"]}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Empty Permissions",
+			inputJSON:      `{"permissions": []}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Invalid JSON",
+			inputJSON:      `{"permissions": [`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.put(t, UserV1+"/2/permissions", []byte(tt.inputJSON))
+			assert.Equal(t, code, tt.expectedStatus)
+
+			if tt.expectedStatus == http.StatusOK {
+				var response struct {
+					Permissions data.Permissions `json:"permissions"`
+				}
+
+				err := json.Unmarshal(body, &response)
+				assert.NilError(t, err)
+				assert.Equal(t, response.Permissions.Include(data.PermissionAdminPermissions), true)
+				assert.StringContains(t, tl.GetLogOutput(), "user permissions changed")
+			}
+		})
+	}
+}
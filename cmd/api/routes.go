@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"regexp"
 	"strings"
+
+	"greenlight.honganhpham.net/internal/data"
 )
 
 // Empty struct takes zero memory + uniquely identify the key for type safety
@@ -26,17 +28,47 @@ type route struct {
 func (app *application) routes() []route {
 	return []route{
 		newRoute(http.MethodGet, HealthCheckV1, app.healthCheckHandler),
-		newRoute(http.MethodPost, MovieV1, app.requireActivatedUser(app.createMovieHandler)),
-		newRoute(http.MethodGet, MovieV1, app.requireActivatedUser(app.listMovieHandler)),
-		newRoute(http.MethodGet, MovieV1+"/([0-9]+)", app.requireActivatedUser(app.showMovieHandler)),
-		newRoute(http.MethodPatch, MovieV1+"/([0-9]+)", app.requireActivatedUser(app.updateMovieHandler)),
-		newRoute(http.MethodDelete, MovieV1+"/([0-9]+)", app.requireActivatedUser(app.deleteMovieHandler)),
+		newRoute(http.MethodPost, MovieV1, app.requirePermission(data.PermissionMoviesWrite, app.createMovieHandler)),
+		newRoute(http.MethodGet, MovieV1, app.requirePermission(data.PermissionMoviesRead, app.listMovieHandler)),
+		newRoute(http.MethodGet, MovieV1+"/search", app.requirePermission(data.PermissionMoviesRead, app.searchMoviesHandler)),
+		newRoute(http.MethodGet, MovieV1+"/([0-9]+)", app.requirePermission(data.PermissionMoviesRead, app.showMovieHandler)),
+		newRoute(http.MethodPatch, MovieV1+"/([0-9]+)", app.requirePermission(data.PermissionMoviesWrite, app.updateMovieHandler)),
+		newRoute(http.MethodDelete, MovieV1+"/([0-9]+)", app.requirePermission(data.PermissionMoviesWrite, app.deleteMovieHandler)),
 		newRoute(http.MethodPost, UserV1, app.registerUserHandler),
 		newRoute(http.MethodPost, UserV1, app.registerUserHandler),
 		newRoute(http.MethodPut, UserV1+"/activated", app.activateUserHandler),
+		newRoute(http.MethodPut, UserV1+"/password", app.updateUserPasswordHandler),
+		newRoute(http.MethodPut, UserV1+"/email", app.confirmEmailChangeHandler),
+		newRoute(http.MethodGet, UserV1+"/me", app.requireAuthenticatedUser(app.showCurrentUserHandler)),
+		newRoute(http.MethodPatch, UserV1+"/me", app.requireAuthenticatedUser(app.updateCurrentUserHandler)),
+		newRoute(http.MethodDelete, UserV1+"/me", app.requireAuthenticatedUser(app.deleteCurrentUserHandler)),
+		newRoute(http.MethodGet, UserV1+"/me/export", app.requireAuthenticatedUser(app.exportCurrentUserHandler)),
+		newRoute(http.MethodPost, UserV1+"/me/2fa", app.requireActivatedUser(app.enrollTwoFactorHandler)),
+		newRoute(http.MethodPut, UserV1+"/me/2fa", app.requireActivatedUser(app.enableTwoFactorHandler)),
+		newRoute(http.MethodDelete, UserV1+"/me/2fa", app.requireActivatedUser(app.disableTwoFactorHandler)),
+		newRoute(http.MethodGet, UserV1+"/([0-9]+)/permissions", app.requirePermission(data.PermissionAdminPermissions, app.showUserPermissionsHandler)),
+		newRoute(http.MethodPut, UserV1+"/([0-9]+)/permissions", app.requirePermission(data.PermissionAdminPermissions, app.grantUserPermissionsHandler)),
+		newRoute(http.MethodDelete, UserV1+"/([0-9]+)/permissions", app.requirePermission(data.PermissionAdminPermissions, app.revokeUserPermissionsHandler)),
+		newRoute(http.MethodPost, APIKeyV1, app.requireActivatedUser(app.createAPIKeyHandler)),
+		newRoute(http.MethodGet, APIKeyV1, app.requireActivatedUser(app.listAPIKeysHandler)),
+		newRoute(http.MethodDelete, APIKeyV1+"/([0-9]+)", app.requireActivatedUser(app.deleteAPIKeyHandler)),
+		newRoute(http.MethodPost, OAuthV1+"/clients", app.requireActivatedUser(app.registerOAuthClientHandler)),
+		newRoute(http.MethodGet, OAuthV1+"/authorize", app.requireActivatedUser(app.authorizeOAuthHandler)),
+		newRoute(http.MethodPost, OAuthV1+"/token", app.createOAuthTokenHandler),
+		newRoute(http.MethodPost, OAuthV1+"/introspect", app.introspectOAuthTokenHandler),
+		newRoute(http.MethodGet, AuditV1, app.requirePermission(data.PermissionAdminAudit, app.listAuditEventsHandler)),
+		newRoute(http.MethodGet, AuditV1+"/verify", app.requirePermission(data.PermissionAdminAudit, app.verifyAuditEventsHandler)),
+		newRoute(http.MethodPost, InvitationV1, app.requirePermission(data.PermissionAdminInvitations, app.createInvitationHandler)),
+		newRoute(http.MethodGet, InvitationV1, app.requirePermission(data.PermissionAdminInvitations, app.listInvitationsHandler)),
 		newRoute(http.MethodGet, "/panic", app.panicHandler),
 		newRoute(http.MethodPost, TokenV1+"/activation", app.createActivationTokenHandler),
 		newRoute(http.MethodPost, TokenV1+"/authentication", app.createAuthenticationTokenHandler),
+		newRoute(http.MethodDelete, TokenV1+"/authentication", app.requireAuthenticatedUser(app.deleteAuthenticationTokenHandler)),
+		newRoute(http.MethodDelete, TokenV1+"/authentication/all", app.requireAuthenticatedUser(app.deleteAllAuthenticationTokensHandler)),
+		newRoute(http.MethodGet, TokenV1+"/sessions", app.requireAuthenticatedUser(app.listSessionsHandler)),
+		newRoute(http.MethodDelete, TokenV1+"/sessions/([0-9]+)", app.requireAuthenticatedUser(app.deleteSessionHandler)),
+		newRoute(http.MethodPost, TokenV1+"/refresh", app.refreshAuthenticationTokenHandler),
+		newRoute(http.MethodPost, TokenV1+"/password-reset", app.createPasswordResetTokenHandler),
 	}
 }
 
@@ -24,6 +24,12 @@ func (app *application) serve() error {
 
 	shutdownError := make(chan error)
 
+	// Closed once the server stops accepting requests to tell background workers to exit
+	stopWorkers := make(chan struct{})
+	app.startTokenCleanup(stopWorkers)
+	app.startAccountCleanup(stopWorkers)
+	app.startAuditAnchor(stopWorkers)
+
 	// Stop accepting new HTTP requests
 	// Give in-flight ones 20 seconds to complete
 	go func() {
@@ -53,6 +59,8 @@ func (app *application) serve() error {
 			"addr": srv.Addr,
 		})
 
+		close(stopWorkers)
+
 		// Wait until the counter is 0 i.e. no background goroutine running left
 		app.wg.Wait()
 		shutdownError <- nil
@@ -6,8 +6,10 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"greenlight.honganhpham.net/internal/assert"
+	"greenlight.honganhpham.net/internal/data"
 	"greenlight.honganhpham.net/internal/logger"
 	"greenlight.honganhpham.net/internal/mocks"
 )
@@ -49,6 +51,12 @@ func (tl *testLogger) Reset() {
 
 func newTestApplication(_ *testing.T, tl *testLogger) *application {
 	return &application{
+		config: config{
+			auth:         AuthConfig{mode: authModeStateful, accessTTL: 15 * time.Minute, refreshTTL: 24 * time.Hour},
+			lockout:      LockoutConfig{threshold: 5, duration: time.Minute, maxDuration: time.Hour, window: 24 * time.Hour},
+			registration: RegistrationConfig{mode: registrationModeOpen, invitationTTL: 7 * 24 * time.Hour},
+			cursorKey:    "test-cursor-signing-key",
+		},
 		logger: tl.Logger,
 		models: mocks.NewMockModels(),
 		mailer: mocks.NewMockMailer(),
@@ -60,6 +68,13 @@ func newTestServer(_ *testing.T, h http.Handler) *testServer {
 	return &testServer{ts}
 }
 
+// Wrap the handler so every request carries the given user, mimicking a successful authenticate()
+func newAuthenticatedTestServer(t *testing.T, app *application, user *data.User) *testServer {
+	return newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.ServeHTTP(w, app.contextSetUser(r, user))
+	}))
+}
+
 // Make a GET request to a given URL and return the status code, headers and body
 func (ts *testServer) get(t *testing.T, urlPath string) (int, http.Header, string) {
 	rs, err := ts.Client().Get(ts.URL + urlPath)
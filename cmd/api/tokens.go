@@ -51,6 +51,8 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 		return
 	}
 
+	app.audit(r, &data.AuditEvent{ActorID: user.ID, Action: data.AuditTokenCreated, Metadata: map[string]string{"scope": data.ScopeActivation}})
+
 	app.background(func() {
 		data := map[string]any{
 			"activationToken": token.Plaintext,
@@ -75,6 +77,7 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	var input struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		OTP      string `json:"otp"` // Code or recovery code, only needed with 2FA enabled
 	}
 	err := app.readJSON(w, r, &input)
 
@@ -93,6 +96,194 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	// Locked accounts, unknown emails and wrong passwords all take the same time to answer
+	// So response timing leaks neither whether the account exists nor whether it is locked
+	result := consistentTime(minProcessingTime, func() loginResult {
+		return app.checkLogin(input.Email, input.Password, input.OTP)
+	})
+
+	user := result.user
+
+	if err := result.err; err != nil {
+		app.auditFailedLogin(r, input.Email, user, err)
+
+		switch {
+		case errors.Is(err, errAccountLocked):
+			app.accountLockedResponse(w, r, result.retryAfter)
+		// An unknown email gets the same answer as a wrong password, or the response would reveal who has an account
+		case errors.Is(err, data.ErrRecordNotFound), errors.Is(err, errInvalidCredentials):
+			app.invalidCredentialsResponse(w, r)
+		case errors.Is(err, errInvalidOTP):
+			app.otpRequiredResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.rehashPassword(r, user, input.Password)
+
+	app.audit(r, &data.AuditEvent{ActorID: user.ID, Action: data.AuditLogin, Metadata: map[string]string{"method": "password"}})
+
+	// Every login starts a new family of access and refresh tokens
+	family, err := data.NewTokenFamily()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeTokenPair(w, r, user, family)
+}
+
+// Outcome of a password login, the user is set whenever the email matched an account
+type loginResult struct {
+	user       *data.User
+	retryAfter time.Duration // Only set with errAccountLocked
+	err        error
+}
+
+// Check the lockout, the password and the second factor, counting failures towards the lockout
+func (app *application) checkLogin(email, password, otp string) loginResult {
+	retryAfter, err := app.lockedFor(email)
+	if err != nil {
+		return loginResult{err: err}
+	}
+
+	// Refuse to even compare the password while locked
+	if retryAfter > 0 {
+		return loginResult{retryAfter: retryAfter, err: errAccountLocked}
+	}
+
+	user, err := app.models.Users.GetByEmail(email)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			// Spend as long as a wrong password would, so timing does not reveal which accounts exist
+			// Hashing costs the same as comparing against a hash made with the current parameters
+			_, _ = app.models.Hasher.Hash(password)
+
+			if err := app.recordFailedLogin(email); err != nil {
+				return loginResult{err: err}
+			}
+		}
+		return loginResult{err: err}
+	}
+
+	match, err := user.Password.Matches(password)
+	if err != nil {
+		return loginResult{user: user, err: err}
+	}
+
+	if !match {
+		if err := app.recordFailedLogin(email); err != nil {
+			return loginResult{user: user, err: err}
+		}
+		return loginResult{user: user, err: errInvalidCredentials}
+	}
+
+	tf, err := app.enabledTwoFactor(user.ID)
+	if err != nil {
+		return loginResult{user: user, err: err}
+	}
+
+	// A leaked password alone is not enough, and guessing codes counts towards the lockout
+	if tf != nil {
+		err = app.verifySecondFactor(tf, otp)
+		if err != nil {
+			if errors.Is(err, errInvalidOTP) && otp != "" {
+				if err := app.recordFailedLogin(email); err != nil {
+					return loginResult{user: user, err: err}
+				}
+			}
+			return loginResult{user: user, err: err}
+		}
+	}
+
+	return loginResult{user: user, err: app.models.LoginAttempts.Reset(email)}
+}
+
+func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	token, err := app.models.Token.Rotate(data.ScopeRefresh, input.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTokenReused):
+			// The token leaked: the model has already revoked its family, so the thief and the user are both logged out
+			app.logger.Error(err, map[string]string{
+				"error": "refresh token reuse detected",
+			})
+			app.audit(r, &data.AuditEvent{ActorID: token.UserID, Action: data.AuditTokenRevoked, Metadata: map[string]string{"scope": "family", "reason": "refresh_token_reused"}})
+			v.AddError("refresh_token", "invalid or expired refresh token")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("refresh_token", "invalid or expired refresh token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.models.Users.Get(token.UserID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, &data.AuditEvent{ActorID: user.ID, Action: data.AuditTokenCreated, Metadata: map[string]string{"grant": "refresh_token"}})
+
+	env, refreshToken, err := app.newTokenPair(r, user, token.Family)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Signed access tokens never reach the database, so in stateless mode refreshing is the only sign the session is in use
+	err = app.models.Token.Touch(refreshToken.Hash, app.clientIP(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	user, err := app.models.Users.GetByEmail(input.Email)
 	if err != nil {
 		switch {
@@ -105,28 +296,229 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
-	match, err := user.Password.Matches(input.Password)
+	if !user.Activated {
+		v.AddError("email", "user account must be activated")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Short-lived since the token grants a full password change
+	token, err := app.models.Token.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	if !match {
-		app.invalidCredentialsResponse(w, r)
+	app.audit(r, &data.AuditEvent{ActorID: user.ID, Action: data.AuditTokenCreated, Metadata: map[string]string{"scope": data.ScopePasswordReset}})
+
+	app.background(func() {
+		data := map[string]any{
+			"passwordResetToken": token.Plaintext,
+		}
+
+		err = app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+		if err != nil {
+			app.logger.Error(err, nil)
+		}
+	})
+
+	env := envelope{"message": "an email will be sent to you containing password reset instructions"}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Issue a short-lived access token together with a refresh token from the same family
+func (app *application) writeTokenPair(w http.ResponseWriter, r *http.Request, user *data.User, family []byte) {
+	env, _, err := app.newTokenPair(r, user, family)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	token, err := app.models.Token.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// In stateless mode the access token is signed, with the same JSON shape as a database token
+func (app *application) newTokenPair(r *http.Request, user *data.User, family []byte) (envelope, *data.Token, error) {
+	var accessToken any
+
+	// Shown in the session list so the user can tell their logins apart
+	info := data.SessionInfo{UserAgent: r.UserAgent(), IP: app.clientIP(r)}
+
+	if app.config.auth.mode == authModeStateless {
+		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		token, expiry, err := app.newStatelessToken(user, permissions, family, app.config.auth.accessTTL)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		accessToken = map[string]any{
+			"token":  token,
+			"expiry": expiry,
+		}
+	} else {
+		token, err := app.models.Token.NewInFamily(user.ID, app.config.auth.accessTTL, data.ScopeAuthentication, family, info)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		accessToken = token
+	}
+
+	refreshToken, err := app.models.Token.NewInFamily(user.ID, app.config.auth.refreshTTL, data.ScopeRefresh, family, info)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return envelope{"authentication_token": accessToken, "refresh_token": refreshToken}, refreshToken, nil
+}
+
+// Log out of the current session by revoking the bearer token used for this request and its refresh token
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	token, ok := app.readBearerToken(r)
+	if !ok {
+		app.invalidAuthenticationTokenResponse(w, r)
 		return
 	}
 
-	env := envelope{"authentication_token": token}
+	// A signed token has no row of its own, revoking its login is what makes authenticate() refuse it
+	if app.config.auth.mode == authModeStateless {
+		if _, _, family, err := app.parseStatelessToken(token); err == nil {
+			err = app.models.Token.DeleteFamily(family)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
 
-	err = app.writeJSON(w, http.StatusCreated, env, nil)
+			app.writeTokenRevoked(w, r)
+			return
+		}
+	}
+
+	// The refresh token of the same login goes too, or it could mint new access tokens after logout
+	err := app.models.Token.DeleteFamilyByHash(data.ScopeAuthentication, data.HashTokenPlaintext(token))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeTokenRevoked(w, r)
+}
+
+func (app *application) writeTokenRevoked(w http.ResponseWriter, r *http.Request) {
+	app.audit(r, &data.AuditEvent{ActorID: app.contextGetUser(r).ID, Action: data.AuditTokenRevoked, Metadata: map[string]string{"scope": "current"}})
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"message": "authentication token successfully revoked"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
+}
+
+// Log out of every session by revoking all authentication tokens of the current user
+func (app *application) deleteAllAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	// Refresh tokens would otherwise mint new sessions straight away
+	for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh} {
+		err := app.models.Token.DeleteAllForUser(scope, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.audit(r, &data.AuditEvent{ActorID: user.ID, Action: data.AuditTokenRevoked, Metadata: map[string]string{"scope": "all"}})
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"message": "all authentication tokens successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Revoke every login of the user except the one making this request, e.g. after a password change
+func (app *application) revokeOtherSessions(r *http.Request, userID int64) error {
+	var keepHash, keepFamily []byte
+
+	if token, ok := app.readBearerToken(r); ok {
+		keepHash = data.HashTokenPlaintext(token)
+
+		if app.config.auth.mode == authModeStateless {
+			if _, _, family, err := app.parseStatelessToken(token); err == nil {
+				keepFamily = family
+			}
+		}
+	}
+
+	return app.models.Token.DeleteOtherSessions(userID, keepHash, keepFamily)
+}
+
+// List the user's logins, flagging the one making this request
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	var currentHash, currentFamily []byte
+
+	if token, ok := app.readBearerToken(r); ok {
+		currentHash = data.HashTokenPlaintext(token)
+
+		// A signed token is not stored, only its family ties it to a session
+		if app.config.auth.mode == authModeStateless {
+			if _, _, family, err := app.parseStatelessToken(token); err == nil {
+				currentFamily = family
+			}
+		}
+	}
 
+	sessions, err := app.models.Token.GetSessionsForUser(app.contextGetUser(r).ID, currentHash, currentFamily)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Log a single session out, revoking both its access and refresh tokens
+func (app *application) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Token.DeleteSession(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, &data.AuditEvent{ActorID: user.ID, Action: data.AuditTokenRevoked, TargetType: "session", TargetID: id})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "session successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
@@ -2,11 +2,16 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
 	"greenlight.honganhpham.net/internal/assert"
+	"greenlight.honganhpham.net/internal/data"
+	"greenlight.honganhpham.net/internal/mocks"
 )
 
 func TestCreateActivationTokenHandler(t *testing.T) {
@@ -118,7 +123,6 @@ func TestCreateAuthenticationTokenHandler(t *testing.T) {
 	}{
 		{
 			name: "Valid Credentials",
-			// TODO: No mock password yet
 			inputJSON: `{
                 "email": "mock@example.com",
                 "password": "pa55word"
@@ -141,7 +145,7 @@ func TestCreateAuthenticationTokenHandler(t *testing.T) {
                 "email": "nonexistent@example.com",
                 "password": "pa55word"
             }`,
-			expectedStatus: http.StatusUnprocessableEntity,
+			expectedStatus: http.StatusUnauthorized,
 			wantToken:      false,
 		},
 		{
@@ -158,7 +162,7 @@ func TestCreateAuthenticationTokenHandler(t *testing.T) {
 			inputJSON: `{
                 "password": "pa55word"
             }`,
-			expectedStatus: http.StatusBadRequest,
+			expectedStatus: http.StatusUnprocessableEntity,
 			wantToken:      false,
 		},
 		{
@@ -166,13 +170,13 @@ func TestCreateAuthenticationTokenHandler(t *testing.T) {
 			inputJSON: `{
                 "email": "mock@example.com"
             }`,
-			expectedStatus: http.StatusBadRequest,
+			expectedStatus: http.StatusUnprocessableEntity,
 			wantToken:      false,
 		},
 		{
 			name:           "Empty JSON",
 			inputJSON:      `{}`,
-			expectedStatus: http.StatusBadRequest,
+			expectedStatus: http.StatusUnprocessableEntity,
 			wantToken:      false,
 		},
 		{
@@ -230,3 +234,573 @@ func TestCreateAuthenticationTokenHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestCreatePasswordResetTokenHandler(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+	ts := newTestServer(t, app)
+	defer ts.Close()
+
+	tests := []struct {
+		name           string
+		inputJSON      string
+		expectedStatus int
+	}{
+		{
+			name:           "Valid Activated User",
+			inputJSON:      `{"email": "activated@example.com"}`,
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Unactivated User",
+			inputJSON:      `{"email": "mock@example.com"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Non-existent Email",
+			inputJSON:      `{"email": "nonexistent@example.com"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Invalid Email Format",
+			inputJSON:      `{"email": "not-an-email"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Invalid JSON",
+			inputJSON:      `{"email": "activated@example.com"`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, _ := ts.post(t, TokenV1+"/password-reset", []byte(tt.inputJSON))
+			assert.Equal(t, code, tt.expectedStatus)
+		})
+	}
+}
+
+func TestDeleteAuthenticationTokenHandler(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+	ts := newAuthenticatedTestServer(t, app, mocks.ActivatedUser)
+	defer ts.Close()
+
+	validToken := "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"
+	err := app.models.Token.Insert(&data.Token{
+		Plaintext: validToken,
+		Hash:      data.HashTokenPlaintext(validToken),
+		UserID:    mocks.ActivatedUser.ID,
+		Expiry:    time.Now().Add(time.Hour),
+		Scope:     data.ScopeAuthentication,
+	})
+	assert.NilError(t, err)
+
+	tests := []struct {
+		name           string
+		authorization  string
+		expectedStatus int
+	}{
+		{
+			name:           "Valid Token",
+			authorization:  "Bearer " + validToken,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Already Revoked Token",
+			authorization:  "Bearer " + validToken,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Missing Header",
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodDelete, ts.URL+TokenV1+"/authentication", nil)
+			assert.NilError(t, err)
+
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+
+			rs, err := ts.Client().Do(req)
+			assert.NilError(t, err)
+			rs.Body.Close()
+
+			assert.Equal(t, rs.StatusCode, tt.expectedStatus)
+		})
+	}
+}
+
+func TestRefreshAfterLogout(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+	ts := newAuthenticatedTestServer(t, app, mocks.ActivatedUser)
+	defer ts.Close()
+
+	family, err := data.NewTokenFamily()
+	assert.NilError(t, err)
+
+	access, err := app.models.Token.NewInFamily(mocks.ActivatedUser.ID, time.Hour, data.ScopeAuthentication, family, data.SessionInfo{})
+	assert.NilError(t, err)
+
+	refresh, err := app.models.Token.NewInFamily(mocks.ActivatedUser.ID, time.Hour, data.ScopeRefresh, family, data.SessionInfo{})
+	assert.NilError(t, err)
+
+	req, err := http.NewRequest(http.MethodDelete, ts.URL+TokenV1+"/authentication", nil)
+	assert.NilError(t, err)
+	req.Header.Set("Authorization", "Bearer "+access.Plaintext)
+
+	rs, err := ts.Client().Do(req)
+	assert.NilError(t, err)
+	rs.Body.Close()
+	assert.Equal(t, rs.StatusCode, http.StatusOK)
+
+	code, _, _ := ts.post(t, TokenV1+"/refresh", []byte(`{"refresh_token": "`+refresh.Plaintext+`"}`))
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+}
+
+func TestDeleteStatelessAuthenticationToken(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+	app.config.auth.mode = authModeStateless
+	app.config.auth.signingKey = "0123456789abcdef0123456789abcdef"
+	app.config.auth.revocationCheck = true
+
+	ts := newTestServer(t, app.authenticate(app))
+	defer ts.Close()
+
+	family, err := data.NewTokenFamily()
+	assert.NilError(t, err)
+
+	refresh, err := app.models.Token.NewInFamily(mocks.ActivatedUser.ID, time.Hour, data.ScopeRefresh, family, data.SessionInfo{})
+	assert.NilError(t, err)
+
+	token, _, err := app.newStatelessToken(mocks.ActivatedUser, data.Permissions{data.PermissionMoviesRead}, family, time.Hour)
+	assert.NilError(t, err)
+
+	do := func(method, urlPath string) int {
+		req, err := http.NewRequest(method, ts.URL+urlPath, nil)
+		assert.NilError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		rs, err := ts.Client().Do(req)
+		assert.NilError(t, err)
+		rs.Body.Close()
+
+		return rs.StatusCode
+	}
+
+	assert.Equal(t, do(http.MethodGet, MovieV1+"/1"), http.StatusOK)
+	assert.Equal(t, do(http.MethodDelete, TokenV1+"/authentication"), http.StatusOK)
+
+	// The signed token has not expired, but its login is gone along with the refresh token
+	assert.Equal(t, do(http.MethodGet, MovieV1+"/1"), http.StatusUnauthorized)
+
+	code, _, _ := ts.post(t, TokenV1+"/refresh", []byte(`{"refresh_token": "`+refresh.Plaintext+`"}`))
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+}
+
+func TestDeleteAllAuthenticationTokensHandler(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+
+	tests := []struct {
+		name           string
+		user           *data.User
+		expectedStatus int
+	}{
+		{
+			name:           "Authenticated User",
+			user:           mocks.ActivatedUser,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Anonymous User",
+			user:           data.AnonymousUser,
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newAuthenticatedTestServer(t, app, tt.user)
+			defer ts.Close()
+
+			code, _, _ := ts.delete(t, TokenV1+"/authentication/all")
+			assert.Equal(t, code, tt.expectedStatus)
+		})
+	}
+}
+
+func TestRefreshAuthenticationTokenHandler(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+	ts := newTestServer(t, app)
+	defer ts.Close()
+
+	family, err := data.NewTokenFamily()
+	assert.NilError(t, err)
+
+	original, err := app.models.Token.NewInFamily(mocks.ActivatedUser.ID, time.Hour, data.ScopeRefresh, family, data.SessionInfo{})
+	assert.NilError(t, err)
+
+	refresh := func(token string) (int, string) {
+		code, _, body := ts.post(t, TokenV1+"/refresh", []byte(`{"refresh_token": "`+token+`"}`))
+
+		var response struct {
+			RefreshToken struct {
+				Plaintext string `json:"token"`
+			} `json:"refresh_token"`
+		}
+
+		if code == http.StatusCreated {
+			err := json.Unmarshal(body, &response)
+			assert.NilError(t, err)
+		}
+
+		return code, response.RefreshToken.Plaintext
+	}
+
+	// First use rotates the token
+	code, rotated := refresh(original.Plaintext)
+	assert.Equal(t, code, http.StatusCreated)
+	assert.Equal(t, rotated != "" && rotated != original.Plaintext, true)
+
+	// Replaying the old token revokes the whole family...
+	code, _ = refresh(original.Plaintext)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, tl.GetLogOutput(), "refresh token reuse detected")
+
+	events, _, err := app.models.Audit.GetAll(data.AuditFilters{Action: data.AuditTokenRevoked}, data.Filters{Page: 1, PageSize: 20, Sort: "id", SortSafeList: []string{"id"}})
+	assert.NilError(t, err)
+	assert.Equal(t, len(events), 1)
+	assert.Equal(t, events[0].ActorID, mocks.ActivatedUser.ID)
+	assert.Equal(t, events[0].Metadata["reason"], "refresh_token_reused")
+
+	// ...including the token issued by the legitimate rotation
+	code, _ = refresh(rotated)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+
+	code, _ = refresh("short")
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+}
+
+func TestCreateAuthenticationTokenHandlerLockout(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+	app.config.lockout = LockoutConfig{threshold: 2, duration: time.Minute, maxDuration: time.Hour, window: time.Hour}
+
+	ts := newTestServer(t, app)
+	defer ts.Close()
+
+	login := func(email, password string) (int, http.Header) {
+		code, headers, _ := ts.post(t, TokenV1+"/authentication", []byte(`{"email": "`+email+`", "password": "`+password+`"}`))
+		return code, headers
+	}
+
+	// A success resets the counter, so one failure followed by a success never locks
+	code, _ := login(mocks.ActivatedUser.Email, "wrongpassword")
+	assert.Equal(t, code, http.StatusUnauthorized)
+	code, _ = login(mocks.ActivatedUser.Email, mocks.MockPassword)
+	assert.Equal(t, code, http.StatusCreated)
+
+	// Reaching the threshold locks the account, even for the correct password
+	for range 2 {
+		code, _ = login(mocks.ActivatedUser.Email, "wrongpassword")
+		assert.Equal(t, code, http.StatusUnauthorized)
+	}
+
+	code, headers := login(mocks.ActivatedUser.Email, mocks.MockPassword)
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.Equal(t, headers.Get("Retry-After") != "", true)
+
+	// Unknown emails are answered and throttled exactly like real ones
+	for range 2 {
+		code, _ = login("nonexistent@example.com", "wrongpassword")
+		assert.Equal(t, code, http.StatusUnauthorized)
+	}
+
+	code, _ = login("nonexistent@example.com", "wrongpassword")
+	assert.Equal(t, code, http.StatusTooManyRequests)
+
+	// A failure outside the window no longer counts towards the lockout
+	code, _ = login("stale@example.com", "wrongpassword")
+	assert.Equal(t, code, http.StatusUnauthorized)
+
+	attempt, err := app.models.LoginAttempts.Get("stale@example.com")
+	assert.NilError(t, err)
+	attempt.LastFailedAt = time.Now().Add(-2 * time.Hour)
+
+	code, _ = login("stale@example.com", "wrongpassword")
+	assert.Equal(t, code, http.StatusUnauthorized)
+
+	attempt, err = app.models.LoginAttempts.Get("stale@example.com")
+	assert.NilError(t, err)
+	assert.Equal(t, attempt.FailedCount, 1)
+}
+
+func TestCreateAuthenticationTokenHandlerRehash(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+
+	ts := newTestServer(t, app)
+	defer ts.Close()
+
+	// Only this application hashes with argon2id
+	app.models.Hasher = data.Argon2idHasher{Memory: 64, Iterations: 1, Parallelism: 1}
+
+	// The mock user is shared with other tests, so put its bcrypt hash back afterwards
+	original := mocks.ActivatedUser.Password
+
+	t.Cleanup(func() {
+		mocks.ActivatedUser.Password = original
+	})
+
+	assert.Equal(t, mocks.ActivatedUser.Password.NeedsRehash(app.models.Hasher), true)
+
+	body := []byte(`{"email": "` + mocks.ActivatedUser.Email + `", "password": "` + mocks.MockPassword + `"}`)
+
+	code, _, _ := ts.post(t, TokenV1+"/authentication", body)
+	assert.Equal(t, code, http.StatusCreated)
+	assert.Equal(t, mocks.ActivatedUser.Password.NeedsRehash(app.models.Hasher), false)
+	assert.StringContains(t, tl.GetLogOutput(), "password rehashed")
+
+	// The upgraded hash still logs in
+	code, _, _ = ts.post(t, TokenV1+"/authentication", body)
+	assert.Equal(t, code, http.StatusCreated)
+}
+
+func TestSessions(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+	ts := newTestServer(t, app.authenticate(app))
+	defer ts.Close()
+
+	do := func(method, path, userAgent, accessToken string, body io.Reader) (int, []byte) {
+		req, err := http.NewRequest(method, ts.URL+path, body)
+		assert.NilError(t, err)
+
+		req.Header.Set("User-Agent", userAgent)
+		if accessToken != "" {
+			req.Header.Set("Authorization", "Bearer "+accessToken)
+		}
+
+		rs, err := ts.Client().Do(req)
+		assert.NilError(t, err)
+		defer rs.Body.Close()
+
+		respBody, err := io.ReadAll(rs.Body)
+		assert.NilError(t, err)
+
+		return rs.StatusCode, respBody
+	}
+
+	// Return the access and refresh tokens issued by a login or a refresh
+	issue := func(path, userAgent, body string) (string, string) {
+		code, respBody := do(http.MethodPost, path, userAgent, "", strings.NewReader(body))
+		assert.Equal(t, code, http.StatusCreated)
+
+		var response struct {
+			AuthenticationToken struct {
+				Token string `json:"token"`
+			} `json:"authentication_token"`
+			RefreshToken struct {
+				Token string `json:"token"`
+			} `json:"refresh_token"`
+		}
+		err := json.Unmarshal(respBody, &response)
+		assert.NilError(t, err)
+
+		return response.AuthenticationToken.Token, response.RefreshToken.Token
+	}
+
+	login := func(userAgent string) (string, string) {
+		return issue(TokenV1+"/authentication", userAgent, `{"email": "`+mocks.ActivatedUser.Email+`", "password": "`+mocks.MockPassword+`"}`)
+	}
+
+	laptop, _ := login("laptop-browser")
+	phone, phoneRefresh := login("phone-app")
+
+	type session struct {
+		ID        int64  `json:"id"`
+		UserAgent string `json:"user_agent"`
+		IP        string `json:"ip"`
+		Current   bool   `json:"current"`
+	}
+
+	list := func(accessToken string) []session {
+		code, body := do(http.MethodGet, TokenV1+"/sessions", "laptop-browser", accessToken, nil)
+		assert.Equal(t, code, http.StatusOK)
+
+		var response struct {
+			Sessions []session `json:"sessions"`
+		}
+		err := json.Unmarshal(body, &response)
+		assert.NilError(t, err)
+
+		return response.Sessions
+	}
+
+	sessions := list(laptop)
+	assert.Equal(t, len(sessions), 2)
+
+	// Neither the tokens nor their hashes are exposed
+	code, body := do(http.MethodGet, TokenV1+"/sessions", "laptop-browser", laptop, nil)
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, strings.Contains(string(body), "MOCKTOKEN"), false)
+
+	var phoneSession session
+	for _, s := range sessions {
+		if s.UserAgent == "phone-app" {
+			phoneSession = s
+			assert.Equal(t, s.Current, false)
+		} else {
+			assert.Equal(t, s.UserAgent, "laptop-browser")
+			assert.Equal(t, s.Current, true)
+		}
+		assert.Equal(t, s.IP, "127.0.0.1")
+	}
+
+	phoneSessionID := func() int64 {
+		for _, s := range list(laptop) {
+			if s.UserAgent == "phone-app" {
+				return s.ID
+			}
+		}
+		return 0
+	}
+
+	tokens := app.models.Token.(*mocks.MockTokenModel).Tokens
+
+	// The id outlives the access token it was first listed with, and survives a refresh
+	delete(tokens, string(data.HashTokenPlaintext(phone)))
+	assert.Equal(t, phoneSessionID(), phoneSession.ID)
+
+	phone, _ = issue(TokenV1+"/refresh", "phone-app", `{"refresh_token": "`+phoneRefresh+`"}`)
+	assert.Equal(t, phoneSessionID(), phoneSession.ID)
+
+	code, _ = do(http.MethodDelete, fmt.Sprintf("%s/sessions/%d", TokenV1, phoneSession.ID), "laptop-browser", laptop, nil)
+	assert.Equal(t, code, http.StatusOK)
+
+	// Killing the session revokes its tokens, the other one is untouched
+	_, ok := tokens[string(data.HashTokenPlaintext(phone))]
+	assert.Equal(t, ok, false)
+	assert.Equal(t, len(list(laptop)), 1)
+
+	code, _ = do(http.MethodDelete, fmt.Sprintf("%s/sessions/%d", TokenV1, phoneSession.ID), "laptop-browser", laptop, nil)
+	assert.Equal(t, code, http.StatusNotFound)
+}
+
+func TestStatelessSessions(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+	app.config.auth.mode = authModeStateless
+	app.config.auth.signingKey = "0123456789abcdef0123456789abcdef"
+
+	ts := newTestServer(t, app.authenticate(app))
+	defer ts.Close()
+
+	family, err := data.NewTokenFamily()
+	assert.NilError(t, err)
+
+	refresh, err := app.models.Token.NewInFamily(mocks.ActivatedUser.ID, time.Hour, data.ScopeRefresh, family, data.SessionInfo{})
+	assert.NilError(t, err)
+
+	_, err = app.models.Token.NewInFamily(mocks.ActivatedUser.ID, time.Hour, data.ScopeRefresh, nil, data.SessionInfo{UserAgent: "other-device"})
+	assert.NilError(t, err)
+
+	// Refreshing is the only use of a stateless session the database sees
+	code, _, body := ts.post(t, TokenV1+"/refresh", []byte(`{"refresh_token": "`+refresh.Plaintext+`"}`))
+	assert.Equal(t, code, http.StatusCreated)
+
+	var tokens struct {
+		AuthenticationToken struct {
+			Token string `json:"token"`
+		} `json:"authentication_token"`
+	}
+	err = json.Unmarshal(body, &tokens)
+	assert.NilError(t, err)
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+TokenV1+"/sessions", nil)
+	assert.NilError(t, err)
+	req.Header.Set("Authorization", "Bearer "+tokens.AuthenticationToken.Token)
+
+	rs, err := ts.Client().Do(req)
+	assert.NilError(t, err)
+	defer rs.Body.Close()
+
+	assert.Equal(t, rs.StatusCode, http.StatusOK)
+
+	var response struct {
+		Sessions []struct {
+			UserAgent  string     `json:"user_agent"`
+			LastUsedAt *time.Time `json:"last_used_at"`
+			Current    bool       `json:"current"`
+		} `json:"sessions"`
+	}
+	err = json.NewDecoder(rs.Body).Decode(&response)
+	assert.NilError(t, err)
+
+	assert.Equal(t, len(response.Sessions), 2)
+
+	// The signed token is matched to its session by the family it carries
+	for _, session := range response.Sessions {
+		assert.Equal(t, session.Current, session.UserAgent != "other-device")
+		assert.Equal(t, session.LastUsedAt != nil, session.Current)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"greenlight.honganhpham.net/internal/data"
+	"greenlight.honganhpham.net/internal/totp"
+	"greenlight.honganhpham.net/internal/validator"
+)
+
+// Shown as the account name in authenticator apps
+const totpIssuer = "Greenlight"
+
+var errInvalidOTP = errors.New("invalid one-time password")
+
+// Accept either a TOTP code or an unused recovery code, each only once
+func (app *application) verifySecondFactor(tf *data.TwoFactor, otp string) error {
+	if otp == "" {
+		return errInvalidOTP
+	}
+
+	if step, ok := totp.Validate(tf.Secret, otp, time.Now()); ok {
+		err := app.models.TwoFactor.UseStep(tf.UserID, step)
+		if errors.Is(err, data.ErrRecordNotFound) {
+			// The code was already used, so it may have been shoulder surfed
+			return errInvalidOTP
+		}
+		return err
+	}
+
+	err := app.models.TwoFactor.UseRecoveryCode(tf.UserID, otp)
+	if errors.Is(err, data.ErrRecordNotFound) {
+		return errInvalidOTP
+	}
+	return err
+}
+
+// Return the 2FA enrollment if it is switched on, nil otherwise
+func (app *application) enabledTwoFactor(userID int64) (*data.TwoFactor, error) {
+	tf, err := app.models.TwoFactor.Get(userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if !tf.Enabled {
+		return nil, nil
+	}
+
+	return tf, nil
+}
+
+// Start enrollment with a fresh secret, 2FA stays off until the first code is verified
+func (app *application) enrollTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.TwoFactor.Enroll(user.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			v := validator.New()
+			v.AddError("two_factor", "is already enabled")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The context user may be rebuilt from a signed token without an email
+	account, err := app.models.Users.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"two_factor": map[string]string{
+		"secret": secret,
+		"uri":    totp.URI(totpIssuer, account.Email, secret),
+	}}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Switch 2FA on once the authenticator app proved it produces valid codes
+func (app *application) enableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		OTP string `json:"otp"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	v := validator.New()
+
+	tf, err := app.models.TwoFactor.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("two_factor", "must be enrolled first")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if tf.Enabled {
+		v.AddError("two_factor", "is already enabled")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	step, ok := totp.Validate(tf.Secret, input.OTP, time.Now())
+	if !ok {
+		v.AddError("otp", "is invalid")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	codes, err := data.GenerateRecoveryCodes(data.RecoveryCodeCount)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.TwoFactor.Enable(user.ID, step, codes)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The recovery codes are only ever shown in this response
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Switch 2FA off, which takes both the password and a second factor
+func (app *application) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password string `json:"password"`
+		OTP      string `json:"otp"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	retryAfter, err := app.confirmPassword(user, input.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, errAccountLocked):
+			app.accountLockedResponse(w, r, retryAfter)
+		case errors.Is(err, errInvalidCredentials):
+			v.AddError("password", "is incorrect")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	tf, err := app.enabledTwoFactor(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if tf == nil {
+		v.AddError("two_factor", "is not enabled")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.verifySecondFactor(tf, input.OTP)
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidOTP):
+			v.AddError("otp", "is invalid")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.TwoFactor.Disable(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
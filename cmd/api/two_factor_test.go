@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"greenlight.honganhpham.net/internal/assert"
+	"greenlight.honganhpham.net/internal/mocks"
+	"greenlight.honganhpham.net/internal/totp"
+)
+
+func TestTwoFactorFlow(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+
+	ts := newAuthenticatedTestServer(t, app, mocks.ActivatedUser)
+	defer ts.Close()
+
+	// Logging in goes through the real authenticate middleware
+	loginServer := newTestServer(t, app)
+	defer loginServer.Close()
+
+	login := func(otp string) int {
+		code, _, _ := loginServer.post(t, TokenV1+"/authentication", []byte(`{"email": "`+mocks.ActivatedUser.Email+`", "password": "`+mocks.MockPassword+`", "otp": "`+otp+`"}`))
+		return code
+	}
+
+	code, _, body := ts.post(t, UserV1+"/me/2fa", nil)
+	assert.Equal(t, code, http.StatusCreated)
+	assert.StringContains(t, string(body), "otpauth://totp/Greenlight:")
+
+	var enrollment struct {
+		TwoFactor struct {
+			Secret string `json:"secret"`
+		} `json:"two_factor"`
+	}
+	err := json.Unmarshal(body, &enrollment)
+	assert.NilError(t, err)
+
+	secret := enrollment.TwoFactor.Secret
+
+	// Not enforced until the first code is verified
+	assert.Equal(t, login(""), http.StatusCreated)
+
+	code, _, _ = ts.put(t, UserV1+"/me/2fa", []byte(`{"otp": "000000"}`))
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+
+	otp, err := totp.Code(secret, totp.Step(time.Now()))
+	assert.NilError(t, err)
+
+	code, _, body = ts.put(t, UserV1+"/me/2fa", []byte(`{"otp": "`+otp+`"}`))
+	assert.Equal(t, code, http.StatusOK)
+
+	var enabled struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	err = json.Unmarshal(body, &enabled)
+	assert.NilError(t, err)
+	assert.Equal(t, len(enabled.RecoveryCodes), 10)
+
+	// Re-enrolling would silently swap the secret
+	code, _, _ = ts.post(t, UserV1+"/me/2fa", nil)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+
+	assert.Equal(t, login(""), http.StatusUnauthorized)
+
+	// The code used to enable 2FA cannot be replayed, but the next step's code works once
+	assert.Equal(t, login(otp), http.StatusUnauthorized)
+
+	next, err := totp.Code(secret, totp.Step(time.Now())+1)
+	assert.NilError(t, err)
+
+	assert.Equal(t, login(next), http.StatusCreated)
+	assert.Equal(t, login(next), http.StatusUnauthorized)
+
+	recovery := enabled.RecoveryCodes[0]
+	assert.Equal(t, login(strings.ToUpper(recovery)), http.StatusCreated)
+	assert.Equal(t, login(recovery), http.StatusUnauthorized)
+
+	// The delete helper does not send a body
+	disable := func(otp string) int {
+		req, err := http.NewRequest(http.MethodDelete, ts.URL+UserV1+"/me/2fa", strings.NewReader(`{"password": "`+mocks.MockPassword+`", "otp": "`+otp+`"}`))
+		assert.NilError(t, err)
+
+		rs, err := ts.Client().Do(req)
+		assert.NilError(t, err)
+		rs.Body.Close()
+
+		return rs.StatusCode
+	}
+
+	// Disabling needs the password as well as a second factor
+	assert.Equal(t, disable("000000"), http.StatusUnprocessableEntity)
+	assert.Equal(t, disable(enabled.RecoveryCodes[1]), http.StatusOK)
+
+	assert.Equal(t, login(""), http.StatusCreated)
+}
+
+func TestDisableTwoFactorLockout(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+	app.config.lockout = LockoutConfig{threshold: 2, duration: time.Minute, maxDuration: time.Hour, window: time.Hour}
+
+	ts := newAuthenticatedTestServer(t, app, mocks.ActivatedUser)
+	defer ts.Close()
+
+	disable := func(password string) int {
+		req, err := http.NewRequest(http.MethodDelete, ts.URL+UserV1+"/me/2fa", strings.NewReader(`{"password": "`+password+`", "otp": "000000"}`))
+		assert.NilError(t, err)
+
+		rs, err := ts.Client().Do(req)
+		assert.NilError(t, err)
+		rs.Body.Close()
+
+		return rs.StatusCode
+	}
+
+	// Password guesses count towards the same lockout as logins
+	for range 2 {
+		assert.Equal(t, disable("wrongpassword"), http.StatusUnprocessableEntity)
+	}
+
+	assert.Equal(t, disable(mocks.MockPassword), http.StatusTooManyRequests)
+}
@@ -3,19 +3,29 @@ package main
 import (
 	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
 	"greenlight.honganhpham.net/internal/data"
 	"greenlight.honganhpham.net/internal/validator"
 )
 
+// Signals invalid input from inside consistentTime, so the response is written once after it returns
+var errFailedValidation = errors.New("failed validation")
+
 type registration struct {
-	Name     string `json:"name"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Name       string `json:"name"`
+	Email      string `json:"email"`
+	Password   string `json:"password"`
+	Invitation string `json:"invitation"` // Only read in invite-only mode
 }
 
 func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Request) {
+	if app.config.registration.mode == registrationModeClosed {
+		app.registrationClosedResponse(w, r)
+		return
+	}
+
 	var input registration
 
 	err := app.readJSON(w, r, &input)
@@ -26,33 +36,59 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 
 	v := validator.New()
 
+	inviteOnly := app.config.registration.mode == registrationModeInvite
+
+	if inviteOnly {
+		if data.ValidateInvitationCode(v, input.Invitation); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+
 	var user *data.User
+	var invitation *data.Invitation
 	// This part is way overkill: Ensure the time taken to send the response is always the same
-	err = app.consistentTimeHandler(func() error {
+	// Runs synchronously, so user and invitation are only read once the operation has returned
+	err = consistentTime(minProcessingTime, func() error {
 		user = &data.User{
 			Name:      input.Name,
 			Email:     input.Email,
 			Activated: false,
 		}
 
-		err = user.Password.Set(input.Password)
+		err := user.Password.Set(app.models.Hasher, input.Password)
 		if err != nil {
-			app.serverErrorResponse(w, r, err)
 			return err
 		}
 
-		if data.ValidateUser(v, user); !v.Valid() {
-			app.failedValidationResponse(w, r, v.Errors)
-			return err
+		if data.ValidateUser(v, user, app.models.PasswordPolicy); !v.Valid() {
+			return errFailedValidation
+		}
+
+		// Claim the invitation first so it cannot be used twice, handing it back if the insert fails
+		if inviteOnly {
+			invitation, err = app.models.Invitations.Consume(input.Invitation, user.Email)
+			if err != nil {
+				return err
+			}
 		}
 
 		// Test timeout
 		// time.Sleep(4 *time.Second)
 
-		return app.models.Users.Insert(user)
-	}, minProcessingTime)
+		err = app.models.Users.Insert(user)
+		if err != nil && invitation != nil {
+			if err := app.models.Invitations.Release(invitation.ID); err != nil {
+				app.logError(r, err)
+			}
+		}
+
+		return err
+	})
 	if err != nil {
 		switch {
+		case errors.Is(err, errFailedValidation):
+			app.failedValidationResponse(w, r, v.Errors)
 		// FIXME: Change response message to send email even if duplicate
 		case errors.Is(err, data.ErrDuplicateEmail):
 			app.logger.Error(err, map[string]string{
@@ -60,18 +96,35 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 			})
 			v.AddError("email", "a user with this email address already exists")
 			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("invitation", "invalid, used or expired invitation for this email address")
+			app.failedValidationResponse(w, r, v.Errors)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
+	// New users can only read movies until they are granted more permissions, unless invited with more
+	permissions := []string{data.PermissionMoviesRead}
+	if invitation != nil {
+		permissions = append(permissions, invitation.Permissions...)
+	}
+
+	err = app.models.Permissions.AddForUser(user.ID, permissions...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	token, err := app.models.Token.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	app.audit(r, &data.AuditEvent{ActorID: user.ID, Action: data.AuditTokenCreated, Metadata: map[string]string{"scope": data.ScopeActivation}})
+
 	// Reduce round-trip latency
 	// This will be executed CONCURRENTLY
 	app.background(func() {
@@ -143,8 +196,428 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	app.audit(r, &data.AuditEvent{ActorID: user.ID, Action: data.AuditUserActivated})
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidatePasswordPlaintext(v, input.Password)
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopePasswordReset, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The policy needs the user to reject passwords containing their name or email
+	if data.ValidateNewPassword(v, input.Password, user, app.models.PasswordPolicy); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = user.Password.Set(app.models.Hasher, input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The reset token is single-use and any session opened with the old password must go
+	for _, scope := range []string{data.ScopePasswordReset, data.ScopeAuthentication, data.ScopeRefresh} {
+		err = app.models.Token.DeleteAllForUser(scope, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.audit(r, &data.AuditEvent{ActorID: user.ID, Action: data.AuditTokenRevoked, Metadata: map[string]string{"scope": "all", "reason": "password_reset"}})
+
+	env := envelope{"message": "your password was successfully reset"}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	// Reload the user as stateless tokens and API keys do not carry the full record
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Verify the user version in the db matches the expected version in the header
+	if r.Header.Get("X-Expected-Version") != "" {
+		if strconv.Itoa(user.Version) != r.Header.Get("X-Expected-Version") {
+			app.editConflictResponse(w, r)
+			return
+		}
+	}
+
+	var input struct {
+		Name            *string `json:"name"`
+		Email           *string `json:"email"`
+		Password        *string `json:"password"`
+		CurrentPassword *string `json:"current_password"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	emailChanged := input.Email != nil && *input.Email != user.Email
+
+	// Someone holding a stolen token must not be able to take the account over
+	if emailChanged || input.Password != nil {
+		if input.CurrentPassword == nil {
+			v.AddError("current_password", "must be provided to change email or password")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		retryAfter, err := app.confirmPassword(user, *input.CurrentPassword)
+		if err != nil {
+			switch {
+			case errors.Is(err, errAccountLocked):
+				app.accountLockedResponse(w, r, retryAfter)
+			case errors.Is(err, errInvalidCredentials):
+				v.AddError("current_password", "is incorrect")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+
+	// The new address only replaces the current one once it is confirmed through PUT /v1/users/email
+	if emailChanged {
+		if data.ValidateEmail(v, *input.Email); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		_, err = app.models.Users.GetByEmail(*input.Email)
+		switch {
+		case err == nil:
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		case !errors.Is(err, data.ErrRecordNotFound):
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if input.Password != nil {
+		err = user.Password.Set(app.models.Hasher, *input.Password)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if data.ValidateUser(v, user, app.models.PasswordPolicy); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Whoever knew the old password must not keep a session, the device making the change stays logged in
+	if input.Password != nil {
+		err = app.revokeOtherSessions(r, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.audit(r, &data.AuditEvent{ActorID: user.ID, Action: data.AuditTokenRevoked, Metadata: map[string]string{"scope": "others"}})
+	}
+
+	env := envelope{"user": user}
+
+	if emailChanged {
+		token, err := app.models.Token.NewEmailChange(user.ID, 24*time.Hour, *input.Email)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.background(func() {
+			data := map[string]any{
+				"emailChangeToken": token.Plaintext,
+				"email":            token.PendingEmail,
+			}
+
+			err := app.mailer.Send(token.PendingEmail, "token_email_change.tmpl", data)
+			if err != nil {
+				app.logger.Error(err, nil)
+			}
+
+			// Warn the current owner in case the account was taken over
+			data = map[string]any{
+				"name":  user.Name,
+				"email": token.PendingEmail,
+			}
+
+			err = app.mailer.Send(user.Email, "email_change_notice.tmpl", data)
+			if err != nil {
+				app.logger.Error(err, nil)
+			}
+		})
+
+		env["message"] = "an email will be sent to the new address containing confirmation instructions"
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Apply a pending email change once the new address has been proven
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.ChangeEmail(input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Soft delete the account, it is purged for good once the grace period is over
+func (app *application) deleteCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	// Same reasoning as changing the email, a stolen token alone must not be enough
+	if data.ValidatePasswordPlaintext(v, input.Password); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	retryAfter, err := app.confirmPassword(user, input.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, errAccountLocked):
+			app.accountLockedResponse(w, r, retryAfter)
+		case errors.Is(err, errInvalidCredentials):
+			v.AddError("password", "is incorrect")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Users.SoftDelete(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Log every device out right away instead of waiting for the hard delete
+	for _, scope := range []string{data.ScopeActivation, data.ScopeAuthentication, data.ScopePasswordReset, data.ScopeRefresh, data.ScopeOAuthAccess} {
+		err = app.models.Token.DeleteAllForUser(scope, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.audit(r, &data.AuditEvent{ActorID: user.ID, Action: data.AuditTokenRevoked, Metadata: map[string]string{"scope": "all", "reason": "account_deleted"}})
+
+	app.logger.Info("user account deleted", map[string]string{
+		"user_id": strconv.FormatInt(user.ID, 10),
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"message": "your account has been scheduled for deletion"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Return everything we hold about the current user as a downloadable JSON archive
+func (app *application) exportCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	tokens, err := app.models.Token.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Token plaintexts are unknown and the hashes are useless to the user, so only export the metadata
+	type tokenMetadata struct {
+		Scope  string    `json:"scope"`
+		Expiry time.Time `json:"expiry"`
+	}
+
+	tokensMetadata := make([]tokenMetadata, 0, len(tokens))
+	for _, token := range tokens {
+		tokensMetadata = append(tokensMetadata, tokenMetadata{Scope: token.Scope, Expiry: token.Expiry})
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	apiKeys, err := app.models.APIKeys.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	movies, err := app.models.Movies.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Content-Disposition", `attachment; filename="greenlight-export.json"`)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{
+		"user":        user,
+		"tokens":      tokensMetadata,
+		"permissions": permissions,
+		"api_keys":    apiKeys,
+		"movies":      movies,
+		"exported_at": time.Now().UTC(),
+	}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
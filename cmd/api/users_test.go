@@ -3,9 +3,13 @@ package main
 import (
 	"encoding/json"
 	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"greenlight.honganhpham.net/internal/assert"
+	"greenlight.honganhpham.net/internal/data"
+	"greenlight.honganhpham.net/internal/mocks"
 )
 
 func TestRegisterUserHandler(t *testing.T) {
@@ -81,6 +85,24 @@ func TestRegisterUserHandler(t *testing.T) {
                 "name": "John Doe",
                 "email": "john@example.com",
                 "password": "short"
+            }`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name: "Common Password",
+			inputJSON: `{
+                "name": "John Doe",
+                "email": "john@example.com",
+                "password": "password123"
+            }`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name: "Password Contains Name",
+			inputJSON: `{
+                "name": "John Doe",
+                "email": "jd@example.com",
+                "password": "johnny2024"
             }`,
 			expectedStatus: http.StatusUnprocessableEntity,
 		},
@@ -269,6 +291,246 @@ func TestActivateUserHandler(t *testing.T) {
 	}
 }
 
+func TestUpdateUserPasswordHandler(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+	ts := newTestServer(t, app)
+	defer ts.Close()
+
+	tests := []struct {
+		name           string
+		inputJSON      string
+		expectedStatus int
+	}{
+		{
+			name: "Valid Reset",
+			inputJSON: `{
+                "password": "n3wpa55word",
+                "token": "P4B3URJZJ2NW5UPZC2OHN4H2NM"
+            }`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Password Too Short",
+			inputJSON: `{
+                "password": "short",
+                "token": "P4B3URJZJ2NW5UPZC2OHN4H2NM"
+            }`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name: "Invalid Token Format",
+			inputJSON: `{
+                "password": "n3wpa55word",
+                "token": "short"
+            }`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Empty JSON",
+			inputJSON:      `{}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, _ := ts.put(t, UserV1+"/password", []byte(tt.inputJSON))
+			assert.Equal(t, code, tt.expectedStatus)
+		})
+	}
+}
+
+func TestShowCurrentUserHandler(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+
+	tests := []struct {
+		name           string
+		user           *data.User
+		expectedStatus int
+	}{
+		{
+			name:           "Authenticated User",
+			user:           mocks.ActivatedUser,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Anonymous User",
+			user:           data.AnonymousUser,
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newAuthenticatedTestServer(t, app, tt.user)
+			defer ts.Close()
+
+			code, _, body := ts.get(t, UserV1+"/me")
+			assert.Equal(t, code, tt.expectedStatus)
+
+			if tt.expectedStatus == http.StatusOK {
+				assert.StringContains(t, body, tt.user.Email)
+			}
+		})
+	}
+}
+
+func TestUpdateCurrentUserHandler(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+	ts := newAuthenticatedTestServer(t, app, mocks.ActivatedUser)
+	defer ts.Close()
+
+	tests := []struct {
+		name           string
+		inputJSON      string
+		expectedStatus int
+		expectedBody   func(*testing.T, []byte)
+	}{
+		{
+			name:           "Change Name",
+			inputJSON:      `{"name": "Renamed User"}`,
+			expectedStatus: http.StatusOK,
+			expectedBody: func(t *testing.T, body []byte) {
+				assert.StringContains(t, string(body), "Renamed User")
+			},
+		},
+		{
+			name:           "Change Email Without Current Password",
+			inputJSON:      `{"email": "new@example.com"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Change Password With Wrong Current Password",
+			inputJSON:      `{"password": "n3wpa55word", "current_password": "wrongpassword"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Change Email",
+			inputJSON:      `{"email": "new@example.com", "current_password": "pa55word"}`,
+			expectedStatus: http.StatusOK,
+			expectedBody: func(t *testing.T, body []byte) {
+				var response struct {
+					User struct {
+						Email     string `json:"email"`
+						Activated bool   `json:"activated"`
+					} `json:"user"`
+				}
+				err := json.Unmarshal(body, &response)
+				assert.NilError(t, err)
+				// Nothing changes until the new address is confirmed
+				assert.Equal(t, response.User.Email, mocks.ActivatedUser.Email)
+				assert.Equal(t, response.User.Activated, true)
+				assert.StringContains(t, string(body), "confirmation instructions")
+			},
+		},
+		{
+			name:           "Change Email To Taken Address",
+			inputJSON:      `{"email": "mock@example.com", "current_password": "pa55word"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Invalid Name",
+			inputJSON:      `{"name": ""}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.patch(t, UserV1+"/me", []byte(tt.inputJSON))
+			assert.Equal(t, code, tt.expectedStatus)
+
+			if tt.expectedBody != nil {
+				tt.expectedBody(t, body)
+			}
+		})
+	}
+}
+
+func TestUpdateCurrentUserHandlerLockout(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+	app.config.lockout = LockoutConfig{threshold: 2, duration: time.Minute, maxDuration: time.Hour, window: time.Hour}
+
+	ts := newAuthenticatedTestServer(t, app, mocks.ActivatedUser)
+	defer ts.Close()
+
+	// Guesses made with a stolen token count towards the same lockout as logins
+	for range 2 {
+		code, _, _ := ts.patch(t, UserV1+"/me", []byte(`{"password": "n3wpa55word!", "current_password": "wrongpassword"}`))
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+	}
+
+	code, _, _ := ts.patch(t, UserV1+"/me", []byte(`{"password": "n3wpa55word!", "current_password": "pa55word"}`))
+	assert.Equal(t, code, http.StatusTooManyRequests)
+}
+
+func TestUpdateCurrentUserPasswordRevokesOtherSessions(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+	ts := newAuthenticatedTestServer(t, app, mocks.ActivatedUser)
+	defer ts.Close()
+
+	login := func() (*data.Token, *data.Token) {
+		family, err := data.NewTokenFamily()
+		assert.NilError(t, err)
+
+		access, err := app.models.Token.NewInFamily(mocks.ActivatedUser.ID, time.Hour, data.ScopeAuthentication, family, data.SessionInfo{})
+		assert.NilError(t, err)
+
+		refresh, err := app.models.Token.NewInFamily(mocks.ActivatedUser.ID, time.Hour, data.ScopeRefresh, family, data.SessionInfo{})
+		assert.NilError(t, err)
+
+		return access, refresh
+	}
+
+	current, currentRefresh := login()
+	_, otherRefresh := login()
+
+	req, err := http.NewRequest(http.MethodPatch, ts.URL+UserV1+"/me", strings.NewReader(`{"password": "n3wpa55word!", "current_password": "pa55word"}`))
+	assert.NilError(t, err)
+	req.Header.Set("Authorization", "Bearer "+current.Plaintext)
+
+	rs, err := ts.Client().Do(req)
+	assert.NilError(t, err)
+	rs.Body.Close()
+	assert.Equal(t, rs.StatusCode, http.StatusOK)
+
+	code, _, _ := ts.post(t, TokenV1+"/refresh", []byte(`{"refresh_token": "`+otherRefresh.Plaintext+`"}`))
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+
+	code, _, _ = ts.post(t, TokenV1+"/refresh", []byte(`{"refresh_token": "`+currentRefresh.Plaintext+`"}`))
+	assert.Equal(t, code, http.StatusCreated)
+}
+
 /*
 	HELPER FUNCTIONS
 */
@@ -286,3 +548,174 @@ func TestActivateUserHandler(t *testing.T) {
 // 	}
 // 	return total / time.Duration(len(durations))
 // }
+
+func TestConfirmEmailChangeHandler(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+	ts := newTestServer(t, app)
+	defer ts.Close()
+
+	token, err := app.models.Token.NewEmailChange(mocks.ActivatedUser.ID, time.Hour, "new@example.com")
+	assert.NilError(t, err)
+
+	taken, err := app.models.Token.NewEmailChange(mocks.ActivatedUser.ID, time.Hour, "mock@example.com")
+	assert.NilError(t, err)
+
+	activation, err := app.models.Token.New(mocks.ActivatedUser.ID, time.Hour, data.ScopeActivation)
+	assert.NilError(t, err)
+
+	tests := []struct {
+		name           string
+		token          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Address Already Taken",
+			token:          taken.Plaintext,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedBody:   "a user with this email address already exists",
+		},
+		{
+			name:           "Wrong Scope",
+			token:          activation.Plaintext,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedBody:   "invalid or expired email change token",
+		},
+		{
+			name:           "Valid Token",
+			token:          token.Plaintext,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "new@example.com",
+		},
+		{
+			name:           "Token Already Used",
+			token:          token.Plaintext,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedBody:   "invalid or expired email change token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.put(t, UserV1+"/email", []byte(`{"token": "`+tt.token+`"}`))
+			assert.Equal(t, code, tt.expectedStatus)
+			assert.StringContains(t, string(body), tt.expectedBody)
+		})
+	}
+}
+
+func TestDeleteCurrentUserHandler(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+	ts := newAuthenticatedTestServer(t, app, mocks.ActivatedUser)
+	defer ts.Close()
+
+	tests := []struct {
+		name           string
+		inputJSON      string
+		expectedStatus int
+	}{
+		{
+			name:           "Missing Password",
+			inputJSON:      `{}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Wrong Password",
+			inputJSON:      `{"password": "wrongpassword"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Valid Password",
+			inputJSON:      `{"password": "pa55word"}`,
+			expectedStatus: http.StatusAccepted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := app.models.Token.New(mocks.ActivatedUser.ID, time.Hour, data.ScopeAuthentication)
+			assert.NilError(t, err)
+
+			// The delete helper does not send a body
+			req, err := http.NewRequest(http.MethodDelete, ts.URL+UserV1+"/me", strings.NewReader(tt.inputJSON))
+			assert.NilError(t, err)
+
+			rs, err := ts.Client().Do(req)
+			assert.NilError(t, err)
+			rs.Body.Close()
+
+			assert.Equal(t, rs.StatusCode, tt.expectedStatus)
+
+			tokens, err := app.models.Token.GetAllForUser(mocks.ActivatedUser.ID)
+			assert.NilError(t, err)
+
+			// A rejected request must leave the sessions alone
+			if tt.expectedStatus == http.StatusAccepted {
+				assert.Equal(t, len(tokens), 0)
+			} else {
+				assert.Equal(t, len(tokens) > 0, true)
+			}
+		})
+	}
+
+	assert.StringContains(t, tl.GetLogOutput(), "user account deleted")
+
+	// The wrong password counted towards the lockout like a failed login
+	attempt, err := app.models.LoginAttempts.Get(mocks.ActivatedUser.Email)
+	assert.NilError(t, err)
+	assert.Equal(t, attempt.FailedCount, 1)
+}
+
+func TestExportCurrentUserHandler(t *testing.T) {
+	tl := newTestLogger(t)
+
+	t.Cleanup(func() {
+		tl.Reset()
+	})
+
+	app := newTestApplication(t, tl)
+	ts := newAuthenticatedTestServer(t, app, mocks.ActivatedUser)
+	defer ts.Close()
+
+	_, err := app.models.Token.New(mocks.ActivatedUser.ID, time.Hour, data.ScopeAuthentication)
+	assert.NilError(t, err)
+
+	code, headers, body := ts.get(t, UserV1+"/me/export")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, headers.Get("Content-Disposition"), "attachment")
+
+	var response struct {
+		User struct {
+			Email string `json:"email"`
+		} `json:"user"`
+		Tokens []struct {
+			Scope string `json:"scope"`
+		} `json:"tokens"`
+		Movies []struct {
+			Title string `json:"title"`
+		} `json:"movies"`
+	}
+
+	err = json.Unmarshal([]byte(body), &response)
+	assert.NilError(t, err)
+
+	assert.Equal(t, response.User.Email, mocks.ActivatedUser.Email)
+	assert.Equal(t, len(response.Tokens), 1)
+	assert.Equal(t, response.Tokens[0].Scope, data.ScopeAuthentication)
+	assert.Equal(t, len(response.Movies), 1)
+
+	// Never leak the secrets themselves
+	assert.Equal(t, strings.Contains(body, "MOCKTOKEN"), false)
+}
@@ -7,3 +7,5 @@ require (
 	github.com/lib/pq v1.10.2
 	golang.org/x/crypto v0.32.0
 )
+
+require golang.org/x/sys v0.29.0 // indirect
@@ -0,0 +1,221 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.honganhpham.net/internal/validator"
+)
+
+// Make keys recognisable e.g. by secret scanners, as they never expire
+const apiKeyPrefix = "glk_"
+
+type APIKey struct {
+	ID          int64       `json:"id"`
+	Plaintext   string      `json:"key,omitempty"` // Only returned once on creation
+	Hash        []byte      `json:"-"`
+	UserID      int64       `json:"-"`
+	Name        string      `json:"name"`
+	Permissions Permissions `json:"permissions"`
+	CreatedAt   time.Time   `json:"created_at"`
+	LastUsedAt  *time.Time  `json:"last_used_at"` // Pointer as the column is NULL until the first use
+}
+
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+type APIKeyModelInterface interface {
+	New(userID int64, name string, permissions Permissions) (*APIKey, error)
+	GetAllForUser(userID int64) ([]*APIKey, error)
+	GetForKey(keyPlaintext string) (*User, Permissions, error)
+	Delete(id, userID int64) error
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey, granted Permissions) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(len(key.Permissions) > 0, "permissions", "must contain at least 1 permission")
+	v.Check(validator.Unique(key.Permissions), "permissions", "must not contain duplicate values")
+
+	// A key can never do more than its owner
+	for _, code := range key.Permissions {
+		v.Check(granted.Include(code), "permissions", "you do not hold the permission "+code)
+	}
+}
+
+func ValidateAPIKeyPlaintext(v *validator.Validator, keyPlaintext string) {
+	v.Check(keyPlaintext != "", "key", "must be provided")
+	v.Check(strings.HasPrefix(keyPlaintext, apiKeyPrefix), "key", "must start with "+apiKeyPrefix)
+	v.Check(len(keyPlaintext) == len(apiKeyPrefix)+52, "key", "must be 56 bytes long")
+}
+
+func (m APIKeyModel) New(userID int64, name string, permissions Permissions) (*APIKey, error) {
+	key, err := generateAPIKey(userID, name, permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+	INSERT INTO api_keys (user_id, name, hash, permissions)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at`
+
+	args := []any{key.UserID, key.Name, key.Hash, pq.Array(key.Permissions)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func (m APIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	query := `
+	SELECT id, user_id, name, permissions, created_at, last_used_at
+	FROM api_keys
+	WHERE user_id = $1
+	ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		var key APIKey
+
+		err := rows.Scan(
+			&key.ID,
+			&key.UserID,
+			&key.Name,
+			pq.Array(&key.Permissions),
+			&key.CreatedAt,
+			&key.LastUsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, &key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Return the owner of the key and the permissions the key is scoped to, recording the usage time
+func (m APIKeyModel) GetForKey(keyPlaintext string) (*User, Permissions, error) {
+	query := `
+	WITH k AS (
+		UPDATE api_keys
+		SET last_used_at = NOW()
+		WHERE hash = $1
+		RETURNING user_id, permissions
+	)
+	SELECT u.id, u.created_at, u.name, u.email, u.password_hash, u.activated, u.version, k.permissions
+	FROM users AS u
+	INNER JOIN k
+	ON u.id = k.user_id
+	WHERE u.deleted_at IS NULL
+	`
+
+	var user User
+	var permissions Permissions
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, HashTokenPlaintext(keyPlaintext)).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+		pq.Array(&permissions),
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	return &user, permissions, nil
+}
+
+// Revoke a key, scoped by user so nobody can delete a key they do not own
+func (m APIKeyModel) Delete(id, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM api_keys
+		WHERE id = $1 AND user_id = $2
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func generateAPIKey(userID int64, name string, permissions Permissions) (*APIKey, error) {
+	// Twice the randomness of a token since the key never expires
+	randomBytes := make([]byte, 32)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext := apiKeyPrefix + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	return &APIKey{
+		Plaintext:   plaintext,
+		Hash:        HashTokenPlaintext(plaintext),
+		UserID:      userID,
+		Name:        name,
+		Permissions: permissions,
+	}, nil
+}
@@ -0,0 +1,24 @@
+package data
+
+import (
+	"bytes"
+	"testing"
+
+	"greenlight.honganhpham.net/internal/assert"
+	"greenlight.honganhpham.net/internal/validator"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, err := generateAPIKey(1, "importer", Permissions{PermissionMoviesRead})
+	assert.NilError(t, err)
+
+	v := validator.New()
+	ValidateAPIKeyPlaintext(v, key.Plaintext)
+	assert.Equal(t, v.Valid(), true)
+
+	assert.Equal(t, bytes.Equal(key.Hash, HashTokenPlaintext(key.Plaintext)), true)
+
+	other, err := generateAPIKey(1, "importer", Permissions{PermissionMoviesRead})
+	assert.NilError(t, err)
+	assert.Equal(t, key.Plaintext != other.Plaintext, true)
+}
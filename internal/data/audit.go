@@ -0,0 +1,339 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"greenlight.honganhpham.net/internal/validator"
+)
+
+// Security-relevant actions recorded in the audit trail
+const (
+	AuditLogin              = "user.login"
+	AuditLoginFailed        = "user.login_failed"
+	AuditUserActivated      = "user.activated"
+	AuditTokenCreated       = "token.created"
+	AuditTokenRevoked       = "token.revoked"
+	AuditAPIKeyCreated      = "api_key.created"
+	AuditAPIKeyRevoked      = "api_key.revoked"
+	AuditPermissionsChanged = "permissions.changed"
+	AuditInvitationCreated  = "invitation.created"
+	AuditMovieCreated       = "movie.created"
+	AuditMovieUpdated       = "movie.updated"
+	AuditMovieDeleted       = "movie.deleted"
+)
+
+var AuditActions = []string{
+	AuditLogin, AuditLoginFailed, AuditUserActivated, AuditTokenCreated, AuditTokenRevoked, AuditAPIKeyCreated,
+	AuditAPIKeyRevoked, AuditPermissionsChanged, AuditInvitationCreated, AuditMovieCreated, AuditMovieUpdated, AuditMovieDeleted,
+}
+
+// Any constant works as long as nothing else takes the same advisory lock
+const auditChainLock = 0x61756469
+
+// Hash every chain starts from
+var auditGenesisHash = make([]byte, sha256.Size)
+
+// Each event carries the hash of the one before, so deleting or editing a row breaks the chain
+// Hashes are keyed, so someone with write access to the table alone cannot rebuild the chain after tampering
+type AuditEvent struct {
+	ID         int64             `json:"id"`
+	CreatedAt  time.Time         `json:"created_at"`
+	ActorID    int64             `json:"actor_id,omitempty"` // Zero when nobody is logged in e.g. a failed login for an unknown email
+	Action     string            `json:"action"`
+	TargetType string            `json:"target_type,omitempty"`
+	TargetID   int64             `json:"target_id,omitempty"`
+	IP         string            `json:"ip,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	PrevHash   []byte            `json:"prev_hash"`
+	Hash       []byte            `json:"hash"`
+}
+
+// HMAC the previous hash together with everything the event records, except the database generated ID
+func (e *AuditEvent) computeHash(key []byte) []byte {
+	// Map keys are sorted by the encoder, so the same metadata always encodes the same way
+	metadata, _ := json.Marshal(e.Metadata)
+
+	h := hmac.New(sha256.New, key)
+	h.Write(e.PrevHash)
+	fmt.Fprintf(h, "\n%d\n%d\n%q\n%q\n%d\n%q\n", e.CreatedAt.Unix(), e.ActorID, e.Action, e.TargetType, e.TargetID, e.IP)
+	h.Write(metadata)
+
+	return h.Sum(nil)
+}
+
+// Link the event after the one with the given hash, nil for the very first event
+func (e *AuditEvent) Chain(key, prev []byte) {
+	if prev == nil {
+		prev = auditGenesisHash
+	}
+
+	e.PrevHash = prev
+	e.Hash = e.computeHash(key)
+}
+
+// Check a chain one event at a time, so it can be verified while reading it without holding it in memory
+type AuditChainVerifier struct {
+	key  []byte
+	prev []byte
+}
+
+func NewAuditChainVerifier(key []byte) *AuditChainVerifier {
+	return &AuditChainVerifier{key: key, prev: auditGenesisHash}
+}
+
+// Report whether the event follows from the ones passed before, in ID order starting from the very first one
+func (v *AuditChainVerifier) Next(e *AuditEvent) bool {
+	if !bytes.Equal(e.PrevHash, v.prev) || !hmac.Equal(e.computeHash(v.key), e.Hash) {
+		return false
+	}
+
+	v.prev = e.Hash
+	return true
+}
+
+// Return the ID of the first event which does not follow from the one before, zero if the chain is intact
+// Events must be in ID order, starting from the very first one
+func VerifyAuditChain(key []byte, events []*AuditEvent) int64 {
+	v := NewAuditChainVerifier(key)
+
+	for _, e := range events {
+		if !v.Next(e) {
+			return e.ID
+		}
+	}
+
+	return 0
+}
+
+// Newest event of the chain and how many events lead up to it
+// The chain alone cannot show that its newest events were deleted, so the head is recorded elsewhere to compare against
+type AuditHead struct {
+	Count int64  `json:"count"`
+	ID    int64  `json:"id"`   // Zero for an empty chain
+	Hash  []byte `json:"hash"` // The genesis hash for an empty chain
+}
+
+type AuditFilters struct {
+	ActorID int64
+	Action  string
+	From    *time.Time
+	To      *time.Time
+}
+
+func ValidateAuditFilters(v *validator.Validator, f AuditFilters) {
+	v.Check(f.ActorID >= 0, "user_id", "must not be negative")
+	v.Check(f.Action == "" || validator.PermittedValue(f.Action, AuditActions...), "action", "invalid action value")
+
+	if f.From != nil && f.To != nil {
+		v.Check(!f.To.Before(*f.From), "to", "must not be before from")
+	}
+}
+
+type AuditModel struct {
+	DB  *sql.DB
+	Key []byte // Keys the hash chain
+}
+
+type AuditModelInterface interface {
+	Insert(event *AuditEvent) error
+	GetAll(af AuditFilters, filters Filters) ([]*AuditEvent, Metadata, error)
+	Verify() (int64, AuditHead, error)
+	Head() (AuditHead, error)
+}
+
+// Append an event to the chain, setting its creation time and hashes
+func (m AuditModel) Insert(event *AuditEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// Safe to call after Commit()
+	defer tx.Rollback()
+
+	// Concurrent inserts must not both chain onto the same previous event
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, auditChainLock)
+	if err != nil {
+		return err
+	}
+
+	var prev []byte
+
+	err = tx.QueryRowContext(ctx, `SELECT hash FROM audit_events ORDER BY id DESC LIMIT 1`).Scan(&prev)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	metadata, err := json.Marshal(event.Metadata)
+	if err != nil {
+		return err
+	}
+
+	// Match the precision of the column, or the stored event would hash differently
+	event.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	event.Chain(m.Key, prev)
+
+	query := `
+	INSERT INTO audit_events (created_at, actor_id, action, target_type, target_id, ip, metadata, prev_hash, hash)
+	VALUES ($1, NULLIF($2::bigint, 0), $3, $4, NULLIF($5::bigint, 0), $6, $7, $8, $9)
+	RETURNING id`
+
+	args := []any{event.CreatedAt, event.ActorID, event.Action, event.TargetType, event.TargetID, event.IP, metadata, event.PrevHash, event.Hash}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&event.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m AuditModel) GetAll(af AuditFilters, filters Filters) ([]*AuditEvent, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, COALESCE(actor_id, 0), action, target_type, COALESCE(target_id, 0), ip, metadata, prev_hash, hash
+	FROM audit_events
+	WHERE (actor_id = $1 OR $1 = 0)
+	AND (action = $2 OR $2 = '')
+	AND (created_at >= $3 OR $3 IS NULL)
+	AND (created_at <= $4 OR $4 IS NULL)
+	ORDER BY %s
+	LIMIT $5 OFFSET $6`, filters.orderBy())
+
+	args := []any{af.ActorID, af.Action, af.From, af.To, filters.limit(), filters.offset()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	events := []*AuditEvent{}
+
+	for rows.Next() {
+		var event AuditEvent
+
+		err := scanAuditEvent(rows, &event, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return events, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Walk the whole chain, returning the ID of the first event that was tampered with or follows a deleted one
+// The head is only returned for an intact chain
+func (m AuditModel) Verify() (int64, AuditHead, error) {
+	query := `
+	SELECT 0, id, created_at, COALESCE(actor_id, 0), action, target_type, COALESCE(target_id, 0), ip, metadata, prev_hash, hash
+	FROM audit_events
+	ORDER BY id`
+
+	// Reads the entire table, so allow more than the usual 3 seconds
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return 0, AuditHead{}, err
+	}
+
+	defer rows.Close()
+
+	// Check each row as it arrives, only the previous hash is kept between rows
+	v := NewAuditChainVerifier(m.Key)
+	head := AuditHead{Hash: auditGenesisHash}
+
+	for rows.Next() {
+		var event AuditEvent
+		var ignored int
+
+		err := scanAuditEvent(rows, &event, &ignored)
+		if err != nil {
+			return 0, AuditHead{}, err
+		}
+
+		if !v.Next(&event) {
+			return event.ID, AuditHead{}, nil
+		}
+
+		head = AuditHead{Count: head.Count + 1, ID: event.ID, Hash: event.Hash}
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, AuditHead{}, err
+	}
+
+	return 0, head, nil
+}
+
+// Return the current head without verifying the chain
+func (m AuditModel) Head() (AuditHead, error) {
+	query := `
+	SELECT (SELECT count(*) FROM audit_events), id, hash
+	FROM audit_events
+	ORDER BY id DESC
+	LIMIT 1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var head AuditHead
+
+	err := m.DB.QueryRowContext(ctx, query).Scan(&head.Count, &head.ID, &head.Hash)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return AuditHead{Hash: auditGenesisHash}, nil
+		default:
+			return AuditHead{}, err
+		}
+	}
+
+	return head, nil
+}
+
+func scanAuditEvent(rows *sql.Rows, event *AuditEvent, totalRecords *int) error {
+	var metadata []byte
+
+	err := rows.Scan(
+		totalRecords,
+		&event.ID,
+		&event.CreatedAt,
+		&event.ActorID,
+		&event.Action,
+		&event.TargetType,
+		&event.TargetID,
+		&event.IP,
+		&metadata,
+		&event.PrevHash,
+		&event.Hash,
+	)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(metadata, &event.Metadata)
+}
@@ -0,0 +1,63 @@
+package data
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"greenlight.honganhpham.net/internal/assert"
+)
+
+var testAuditKey = []byte("test-audit-signing-key")
+
+func newAuditChain(n int) []*AuditEvent {
+	events := []*AuditEvent{}
+
+	var prev []byte
+	for i := range n {
+		e := &AuditEvent{
+			ID:        int64(i + 1),
+			CreatedAt: time.Unix(1700000000+int64(i), 0),
+			ActorID:   2,
+			Action:    AuditLogin,
+			Metadata:  map[string]string{"method": "password"},
+		}
+		e.Chain(testAuditKey, prev)
+		prev = e.Hash
+
+		events = append(events, e)
+	}
+
+	return events
+}
+
+func TestVerifyAuditChain(t *testing.T) {
+	assert.Equal(t, VerifyAuditChain(testAuditKey, nil), int64(0))
+	assert.Equal(t, VerifyAuditChain(testAuditKey, newAuditChain(5)), int64(0))
+
+	// Deleting an event breaks the link of the one after it
+	events := newAuditChain(5)
+	events = slices.Delete(events, 2, 3)
+	assert.Equal(t, VerifyAuditChain(testAuditKey, events), int64(4))
+
+	// So does deleting the very first one
+	events = newAuditChain(5)
+	assert.Equal(t, VerifyAuditChain(testAuditKey, events[1:]), int64(2))
+
+	// Editing an event no longer matches its own hash
+	events = newAuditChain(5)
+	events[1].Metadata["method"] = "api_key"
+	assert.Equal(t, VerifyAuditChain(testAuditKey, events), int64(2))
+
+	events = newAuditChain(5)
+	events[3].ActorID = 3
+	assert.Equal(t, VerifyAuditChain(testAuditKey, events), int64(4))
+
+	// Rebuilding the chain after an edit needs the key
+	events = newAuditChain(5)
+	events[1].ActorID = 3
+	for i, e := range events[1:] {
+		e.Chain([]byte("guessed-key"), events[i].Hash)
+	}
+	assert.Equal(t, VerifyAuditChain(testAuditKey, events), int64(2))
+}
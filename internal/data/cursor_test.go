@@ -0,0 +1,125 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"greenlight.honganhpham.net/internal/assert"
+)
+
+func TestCursorEncoding(t *testing.T) {
+	key := []byte("test-key")
+	cursor := Cursor{Sort: "-title", Values: []string{"Black Panther", "2018"}, ID: 42, Prev: true}
+
+	decoded, err := DecodeCursor(cursor.Encode(key), key)
+	assert.NilError(t, err)
+	assert.Equal(t, fmt.Sprint(*decoded), fmt.Sprint(cursor))
+
+	encoded := cursor.Encode(key)
+	payload, signature, _ := strings.Cut(encoded, ".")
+	otherPayload, _, _ := strings.Cut(Cursor{Sort: "id", ID: 43}.Encode(key), ".")
+
+	for _, s := range []string{
+		"",
+		"garbage",
+		payload,
+		payload + "." + signature[:len(signature)-2],
+		otherPayload + "." + signature,
+	} {
+		_, err := DecodeCursor(s, key)
+		assert.Equal(t, err, ErrInvalidCursor)
+	}
+
+	// Signed with another key
+	_, err = DecodeCursor(encoded, []byte("other-key"))
+	assert.Equal(t, err, ErrInvalidCursor)
+}
+
+func TestFiltersKeyset(t *testing.T) {
+	safeList := []string{"id", "title", "year", "-id", "-title", "-year"}
+
+	tests := []struct {
+		name    string
+		sort    string
+		cursor  *Cursor
+		where   string
+		orderBy string
+		args    int
+	}{
+		{"First Page", "title", nil, "", "title ASC, id ASC", 0},
+		{"Next Ascending", "title", &Cursor{Sort: "title", Values: []string{"M"}, ID: 3}, "(title, id) > ($4, $5)", "title ASC, id ASC", 2},
+		{"Next Descending", "-title", &Cursor{Sort: "-title", Values: []string{"M"}, ID: 3}, "(title, id) < ($4, $5)", "title DESC, id DESC", 2},
+		{"Prev Ascending", "title", &Cursor{Sort: "title", Values: []string{"M"}, ID: 3, Prev: true}, "(title, id) < ($4, $5)", "title DESC, id DESC", 2},
+		{"Prev Descending", "-title", &Cursor{Sort: "-title", Values: []string{"M"}, ID: 3, Prev: true}, "(title, id) > ($4, $5)", "title ASC, id ASC", 2},
+		{"By ID", "-id", &Cursor{Sort: "-id", ID: 3}, "id < $4", "id DESC", 1},
+		{"Several Columns", "-year,-title", &Cursor{Sort: "-year,-title", Values: []string{"2018", "M"}, ID: 3}, "(year, title, id) < ($4, $5, $6)", "year DESC, title DESC, id DESC", 3},
+		{
+			"Mixed Directions",
+			"-year,title",
+			&Cursor{Sort: "-year,title", Values: []string{"2018", "M"}, ID: 3},
+			"((year < $4) OR (year = $4 AND title > $5) OR (year = $4 AND title = $5 AND id > $6))",
+			"year DESC, title ASC, id ASC",
+			3,
+		},
+		{
+			"Mixed Directions Backwards",
+			"-year,title",
+			&Cursor{Sort: "-year,title", Values: []string{"2018", "M"}, ID: 3, Prev: true},
+			"((year > $4) OR (year = $4 AND title < $5) OR (year = $4 AND title = $5 AND id < $6))",
+			"year ASC, title DESC, id DESC",
+			3,
+		},
+		{"Stops At ID", "year,-id,title", &Cursor{Sort: "year,-id,title", Values: []string{"2018"}, ID: 3}, "((year > $4) OR (year = $4 AND id < $5))", "year ASC, id DESC", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filters{Sort: tt.sort, SortSafeList: safeList, Cursor: tt.cursor}
+
+			args := queryArgs{"title", "genres", 20}
+
+			where, orderBy := f.keyset(&args)
+			assert.Equal(t, where, tt.where)
+			assert.Equal(t, orderBy, tt.orderBy)
+			assert.Equal(t, len(args), 3+tt.args)
+		})
+	}
+}
+
+func TestFiltersCursorMetadata(t *testing.T) {
+	key := []byte("test-key")
+	position := func(i int) ([]string, int64) { return nil, int64(i + 10) }
+
+	decode := func(s string) *Cursor {
+		if s == "" {
+			return nil
+		}
+		c, err := DecodeCursor(s, key)
+		assert.NilError(t, err)
+		return c
+	}
+
+	// First page with more to come: only a way forward
+	f := Filters{Sort: "id", PageSize: 3, CursorKey: key}
+	m := f.cursorMetadata(3, true, position)
+	assert.Equal(t, decode(m.NextCursor).ID, int64(12))
+	assert.Equal(t, m.PrevCursor, "")
+
+	// Last page reached going forwards: only a way back
+	f.Cursor = &Cursor{Sort: "id", ID: 9}
+	m = f.cursorMetadata(2, false, position)
+	assert.Equal(t, m.NextCursor, "")
+	assert.Equal(t, decode(m.PrevCursor).ID, int64(10))
+	assert.Equal(t, decode(m.PrevCursor).Prev, true)
+
+	// Back at the first page going backwards: only a way forward
+	f.Cursor = &Cursor{Sort: "id", ID: 13, Prev: true}
+	m = f.cursorMetadata(3, false, position)
+	assert.Equal(t, decode(m.NextCursor).ID, int64(12))
+	assert.Equal(t, m.PrevCursor, "")
+
+	// Empty pages have nowhere to go
+	m = Filters{Sort: "id", PageSize: 3, CursorKey: key}.cursorMetadata(0, false, position)
+	assert.Equal(t, m, Metadata{PageSize: 3})
+}
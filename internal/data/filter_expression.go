@@ -0,0 +1,414 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lib/pq"
+	"greenlight.honganhpham.net/internal/validator"
+)
+
+// Small boolean filter language for list endpoints, e.g.
+//
+//	year>=1990 and genres has "drama" and not title~"part"
+//
+// Grammar, lowest precedence first:
+//
+//	or         = and { "or" and }
+//	and        = unary { "and" unary }
+//	unary      = "not" unary | "(" or ")" | comparison
+//	comparison = field operator value
+//	value      = integer | "string"
+
+const (
+	maxFilterLength = 1000
+	maxFilterDepth  = 20 // Nesting of parentheses and "not", so a crafted filter cannot exhaust the stack
+)
+
+// Kinds of value a filterable field holds, deciding which operators and values it accepts
+const (
+	FilterInt  = "integer" // 32-bit like the integer columns, so larger values are rejected instead of failing the query
+	FilterText = "text"
+	FilterTime = "time" // Compared against RFC 3339 strings
+	FilterTags = "tags" // Text array, only supports "has"
+)
+
+var filterOperators = map[string][]string{
+	FilterInt:  {"=", "!=", "<", "<=", ">", ">="},
+	FilterText: {"=", "!=", "~"},
+	FilterTime: {"<", "<=", ">", ">="},
+	FilterTags: {"has"},
+}
+
+// A field clients may filter on, mapped to its column
+type FilterField struct {
+	Column string
+	Type   string
+}
+
+// Safelist of the fields of one resource, keyed by the name used in filters
+type FilterFields map[string]FilterField
+
+// Node of a parsed filter
+type FilterExpr interface {
+	sql(fields FilterFields, args *queryArgs) string
+}
+
+// "and" or "or" of two expressions
+type FilterBinary struct {
+	Op    string
+	Left  FilterExpr
+	Right FilterExpr
+}
+
+type FilterNot struct {
+	Expr FilterExpr
+}
+
+type FilterComparison struct {
+	Field string
+	Op    string
+	Value any // int64, string or time.Time depending on the field type
+}
+
+// Position is the 1-based character offset in the filter
+type FilterSyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *FilterSyntaxError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Msg, e.Pos)
+}
+
+// Parse and type-check a filter against the safelist, reporting any error under the "filter" key
+func ParseFilter(v *validator.Validator, s string, fields FilterFields) FilterExpr {
+	if len(s) > maxFilterLength {
+		v.AddError("filter", fmt.Sprintf("must not be more than %d bytes long", maxFilterLength))
+		return nil
+	}
+
+	tokens, err := lexFilter(s)
+	if err != nil {
+		v.AddError("filter", err.Error())
+		return nil
+	}
+
+	p := &filterParser{tokens: tokens, fields: fields}
+
+	expr, err := p.parseOr(0)
+	if err == nil && p.peek().kind != tokenEOF {
+		err = p.errorf(p.peek(), "unexpected %s", p.peek())
+	}
+
+	if err != nil {
+		v.AddError("filter", err.Error())
+		return nil
+	}
+
+	return expr
+}
+
+func (e *FilterBinary) sql(fields FilterFields, args *queryArgs) string {
+	return fmt.Sprintf("(%s %s %s)", e.Left.sql(fields, args), strings.ToUpper(e.Op), e.Right.sql(fields, args))
+}
+
+func (e *FilterNot) sql(fields FilterFields, args *queryArgs) string {
+	return fmt.Sprintf("NOT %s", e.Expr.sql(fields, args))
+}
+
+func (e *FilterComparison) sql(fields FilterFields, args *queryArgs) string {
+	field, ok := fields[e.Field]
+	if !ok {
+		// failsafe to help us stop SQL injection attacks, as with sort columns
+		panic("unsafe filter field: " + e.Field)
+	}
+
+	switch e.Op {
+	case "~":
+		return fmt.Sprintf("%s ILIKE %s", field.Column, args.add("%"+escapeLike(e.Value.(string))+"%"))
+	case "has":
+		return fmt.Sprintf("%s @> %s", field.Column, args.add(pq.Array([]string{e.Value.(string)})))
+	default:
+		if !validator.PermittedValue(e.Op, filterOperators[FilterInt]...) {
+			panic("unsafe filter operator: " + e.Op)
+		}
+		return fmt.Sprintf("%s %s %s", field.Column, e.Op, args.add(e.Value))
+	}
+}
+
+// Match "~" values literally, rather than as LIKE patterns
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenInt
+	tokenString
+	tokenOp
+	tokenLParen
+	tokenRParen
+	tokenAnd
+	tokenOr
+	tokenNot
+)
+
+type filterToken struct {
+	kind tokenKind
+	text string // Unquoted for strings
+	pos  int
+}
+
+func (t filterToken) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of filter"
+	case tokenString:
+		return strconv.Quote(t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+var filterKeywords = map[string]tokenKind{"and": tokenAnd, "or": tokenOr, "not": tokenNot}
+
+func lexFilter(s string) ([]filterToken, error) {
+	var tokens []filterToken
+
+	runes := []rune(s)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		start := i
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+
+		case r == '(' || r == ')':
+			kind := tokenLParen
+			if r == ')' {
+				kind = tokenRParen
+			}
+			tokens = append(tokens, filterToken{kind, string(r), start + 1})
+			i++
+
+		case r == '"':
+			var b strings.Builder
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				b.WriteRune(runes[i])
+			}
+			if i == len(runes) {
+				return nil, &FilterSyntaxError{start + 1, "unterminated string"}
+			}
+			i++
+			tokens = append(tokens, filterToken{tokenString, b.String(), start + 1})
+
+		case strings.ContainsRune("=!<>~", r):
+			i++
+			if i < len(runes) && runes[i] == '=' && r != '=' && r != '~' {
+				i++
+			}
+			op := string(runes[start:i])
+			if op == "!" {
+				return nil, &FilterSyntaxError{start + 1, `unexpected "!", use "!=" or "not"`}
+			}
+			tokens = append(tokens, filterToken{tokenOp, op, start + 1})
+
+		case r == '-' || unicode.IsDigit(r):
+			i++
+			for i < len(runes) && unicode.IsDigit(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, filterToken{tokenInt, string(runes[start:i]), start + 1})
+
+		case r == '_' || unicode.IsLetter(r):
+			for i < len(runes) && (runes[i] == '_' || unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+				i++
+			}
+			word := string(runes[start:i])
+
+			switch kind, keyword := filterKeywords[strings.ToLower(word)]; {
+			case keyword:
+				tokens = append(tokens, filterToken{kind, strings.ToLower(word), start + 1})
+			case strings.EqualFold(word, "has"):
+				tokens = append(tokens, filterToken{tokenOp, "has", start + 1})
+			default:
+				tokens = append(tokens, filterToken{tokenIdent, word, start + 1})
+			}
+
+		default:
+			return nil, &FilterSyntaxError{start + 1, fmt.Sprintf("unexpected character %q", r)}
+		}
+	}
+
+	return append(tokens, filterToken{kind: tokenEOF, pos: len(runes) + 1}), nil
+}
+
+// Recursive descent parser over the tokens, type-checking comparisons as it goes
+type filterParser struct {
+	tokens []filterToken
+	next   int
+	fields FilterFields
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.next]
+}
+
+func (p *filterParser) advance() filterToken {
+	t := p.tokens[p.next]
+	if t.kind != tokenEOF {
+		p.next++
+	}
+	return t
+}
+
+func (p *filterParser) errorf(t filterToken, format string, a ...any) error {
+	return &FilterSyntaxError{t.pos, fmt.Sprintf(format, a...)}
+}
+
+func (p *filterParser) parseOr(depth int) (FilterExpr, error) {
+	left, err := p.parseAnd(depth)
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == tokenOr {
+		p.advance()
+
+		right, err := p.parseAnd(depth)
+		if err != nil {
+			return nil, err
+		}
+
+		left = &FilterBinary{Op: "or", Left: left, Right: right}
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseAnd(depth int) (FilterExpr, error) {
+	left, err := p.parseUnary(depth)
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == tokenAnd {
+		p.advance()
+
+		right, err := p.parseUnary(depth)
+		if err != nil {
+			return nil, err
+		}
+
+		left = &FilterBinary{Op: "and", Left: left, Right: right}
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseUnary(depth int) (FilterExpr, error) {
+	if depth > maxFilterDepth {
+		return nil, p.errorf(p.peek(), "filter nested too deeply")
+	}
+
+	switch t := p.peek(); t.kind {
+	case tokenNot:
+		p.advance()
+
+		expr, err := p.parseUnary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		return &FilterNot{Expr: expr}, nil
+
+	case tokenLParen:
+		p.advance()
+
+		expr, err := p.parseOr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		if p.peek().kind != tokenRParen {
+			return nil, p.errorf(p.peek(), "expected \")\" but found %s", p.peek())
+		}
+		p.advance()
+
+		return expr, nil
+
+	default:
+		return p.parseComparison()
+	}
+}
+
+func (p *filterParser) parseComparison() (FilterExpr, error) {
+	name := p.advance()
+	if name.kind != tokenIdent {
+		return nil, p.errorf(name, "expected a field name but found %s", name)
+	}
+
+	field, ok := p.fields[name.text]
+	if !ok {
+		return nil, p.errorf(name, "unknown field %q", name.text)
+	}
+
+	op := p.advance()
+	if op.kind != tokenOp {
+		return nil, p.errorf(op, "expected an operator but found %s", op)
+	}
+
+	if !validator.PermittedValue(op.text, filterOperators[field.Type]...) {
+		return nil, p.errorf(op, "operator %q cannot be used with %s, use one of %s",
+			op.text, name.text, strings.Join(filterOperators[field.Type], " "))
+	}
+
+	value := p.advance()
+
+	comparison := &FilterComparison{Field: name.text, Op: op.text}
+
+	switch {
+	case field.Type == FilterInt && value.kind == tokenInt:
+		i, err := strconv.ParseInt(value.text, 10, 32)
+		if err != nil {
+			if errors.Is(err, strconv.ErrRange) {
+				return nil, p.errorf(value, "integer %s is out of range", value.text)
+			}
+			return nil, p.errorf(value, "invalid integer %s", value)
+		}
+		comparison.Value = i
+
+	case field.Type == FilterTime && value.kind == tokenString:
+		t, err := time.Parse(time.RFC3339, value.text)
+		if err != nil {
+			return nil, p.errorf(value, "%s must be compared with an RFC 3339 timestamp", name.text)
+		}
+		comparison.Value = t
+
+	case (field.Type == FilterText || field.Type == FilterTags) && value.kind == tokenString:
+		comparison.Value = value.text
+
+	case field.Type == FilterInt:
+		return nil, p.errorf(value, "expected an integer but found %s", value)
+
+	default:
+		return nil, p.errorf(value, "expected a quoted string but found %s", value)
+	}
+
+	return comparison, nil
+}
@@ -0,0 +1,92 @@
+package data
+
+import (
+	"strings"
+	"testing"
+
+	"greenlight.honganhpham.net/internal/assert"
+	"greenlight.honganhpham.net/internal/validator"
+)
+
+var testFilterFields = FilterFields{
+	"title":      {Column: "title", Type: FilterText},
+	"year":       {Column: "year", Type: FilterInt},
+	"genres":     {Column: "genres", Type: FilterTags},
+	"created_at": {Column: "created_at", Type: FilterTime},
+}
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		sql    string
+		args   int
+	}{
+		{"Comparison", "year>=1990", "year >= $1", 1},
+		{"Contains", `title ~ "part"`, "title ILIKE $1", 1},
+		{"Has", `genres has "drama"`, "genres @> $1", 1},
+		{"Time", `created_at < "2024-01-01T00:00:00Z"`, "created_at < $1", 1},
+		{
+			"And Binds Tighter Than Or",
+			`year = 1990 or year = 2000 and title != "x"`,
+			"(year = $1 OR (year = $2 AND title != $3))",
+			3,
+		},
+		{
+			"Example",
+			`year>=1990 and genres has "drama" and not title~"part"`,
+			"((year >= $1 AND genres @> $2) AND NOT title ILIKE $3)",
+			3,
+		},
+		{"Parentheses", `not (year < 1990 OR year > 2000)`, "NOT (year < $1 OR year > $2)", 2},
+		{"Escaped Quote", `title = "say \"hi\""`, "title = $1", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+
+			expr := ParseFilter(v, tt.filter, testFilterFields)
+			assert.Equal(t, v.Valid(), true)
+
+			var args queryArgs
+			assert.Equal(t, expr.sql(testFilterFields, &args), tt.sql)
+			assert.Equal(t, len(args), tt.args)
+		})
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		err    string
+	}{
+		{"Unknown Field", `rating > 5`, `unknown field "rating" at position 1`},
+		{"Wrong Operator", `genres = "drama"`, `operator "=" cannot be used with genres, use one of has at position 8`},
+		{"Wrong Value Type", `year = "1990"`, `expected an integer but found "1990" at position 8`},
+		{"Integer Out Of Range", `year > 99999999999`, `integer 99999999999 is out of range at position 8`},
+		{"Bad Timestamp", `created_at > "yesterday"`, `created_at must be compared with an RFC 3339 timestamp at position 14`},
+		{"Missing Value", `year >=`, `expected an integer but found end of filter at position 8`},
+		{"Unclosed Parenthesis", `(year = 1`, `expected ")" but found end of filter at position 10`},
+		{"Trailing Tokens", `year = 1 year`, `unexpected "year" at position 10`},
+		{"Unterminated String", `title = "abc`, `unterminated string at position 9`},
+		{"Bang", `year ! 1`, `unexpected "!", use "!=" or "not" at position 6`},
+		{"Bad Character", `year = 1;`, `unexpected character ';' at position 9`},
+		{"Nested Too Deeply", strings.Repeat("not ", 25) + "year = 1", "filter nested too deeply at position 85"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+
+			expr := ParseFilter(v, tt.filter, testFilterFields)
+			assert.Equal(t, expr, nil)
+			assert.Equal(t, v.Errors["filter"], tt.err)
+		})
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	assert.Equal(t, escapeLike(`100%_\`), `100\%\_\\`)
+}
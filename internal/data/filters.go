@@ -1,8 +1,11 @@
 package data
 
 import (
+	"fmt"
 	"math"
+	"strconv"
 	"strings"
+	"time"
 
 	"greenlight.honganhpham.net/internal/validator"
 )
@@ -12,43 +15,162 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafeList []string
+	CursorMode   bool    // Keyset pagination instead of page numbers
+	Cursor       *Cursor // Where to continue from in cursor mode, nil for the first page
+	CursorKey    []byte  // Signs the cursors returned in the metadata
+	GenresMode   string  // How the genres filter matches: all, any or none of them
+	Year         IntRange
+	Runtime      IntRange
+	Created      TimeRange
+	Expression   FilterExpr   // Parsed from the filter parameter, nil when absent
+	FilterFields FilterFields // Fields the expression may refer to
+}
+
+// Genre match modes mapping to @>, && and NOT &&
+const (
+	GenresModeAll  = "all"
+	GenresModeAny  = "any"
+	GenresModeNone = "none"
+)
+
+// Inclusive bounds, nil for an open end
+type IntRange struct {
+	Min *int
+	Max *int
+}
+
+type TimeRange struct {
+	After  *time.Time
+	Before *time.Time
 }
 
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.Page > 0, "page", "must be greater than zero")
 	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
-	v.Check(f.Page <= 100, "page_size", "must be a maximum of 100")
-	v.Check(validator.PermittedValue(f.Sort, f.SortSafeList...), "sort", "invalid sort value")
+	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
+
+	// Sorting on a column twice is ambiguous, whichever direction each asks for
+	seen := make(map[string]bool)
+	for _, key := range strings.Split(f.Sort, ",") {
+		v.Check(validator.PermittedValue(key, f.SortSafeList...), "sort", "invalid sort value")
+
+		column := strings.TrimPrefix(key, "-")
+		v.Check(!seen[column], "sort", "must not contain duplicate columns")
+		seen[column] = true
+	}
+
+	v.Check(f.GenresMode == "" || validator.PermittedValue(f.GenresMode, GenresModeAll, GenresModeAny, GenresModeNone), "genres_mode", "must be all, any or none")
+
+	validateIntRange(v, "year", f.Year)
+	validateIntRange(v, "runtime", f.Runtime)
+
+	if f.Created.After != nil && f.Created.Before != nil {
+		v.Check(!f.Created.Before.Before(*f.Created.After), "created_before", "must not be before created_after")
+	}
+
+	// A cursor only makes sense for the ordering it was taken from
+	if f.Cursor != nil {
+		v.Check(f.Cursor.Sort == f.Sort, "cursor", "was issued for a different sort")
+	}
+}
+
+func validateIntRange(v *validator.Validator, name string, r IntRange) {
+	if r.Min != nil {
+		v.Check(*r.Min >= 0, name+"_min", "must not be negative")
+	}
+
+	if r.Min != nil && r.Max != nil {
+		v.Check(*r.Min <= *r.Max, name+"_max", "must not be less than "+name+"_min")
+	}
+}
+
+// Positional arguments of a query being built, so every value stays a parameter
+type queryArgs []any
+
+// Append a value, returning its placeholder
+func (a *queryArgs) add(value any) string {
+	*a = append(*a, value)
+	return "$" + strconv.Itoa(len(*a))
+}
+
+// One column of a possibly multi-column sort such as "-year,title"
+type sortKey struct {
+	column    string
+	direction string
 }
 
-func (f Filters) sortColumn() string {
+func (f Filters) sortColumn(key string) string {
 	for _, safeValue := range f.SortSafeList {
-		if f.Sort == safeValue {
-			return strings.TrimPrefix(f.Sort, "-")
+		if key == safeValue {
+			return strings.TrimPrefix(key, "-")
 		}
 	}
 
 	// failsafe to help us stop SQL injection attacks
-	panic("unsafe sort parameter: " + f.Sort)
+	panic("unsafe sort parameter: " + key)
 }
 
-func (f Filters) sortDirection() string {
-	if strings.HasPrefix(f.Sort, "-") {
+func (f Filters) sortDirection(key string) string {
+	if strings.HasPrefix(key, "-") {
 		return "DESC"
 	}
 
 	return "ASC"
 }
 
+// Sort keys in order, up to id as nothing after a unique column changes the order
+// Sorts without id get it appended in the given direction so ties are always broken the same way
+func (f Filters) sortKeys(idDirection string) []sortKey {
+	var keys []sortKey
+
+	for _, key := range strings.Split(f.Sort, ",") {
+		keys = append(keys, sortKey{f.sortColumn(key), f.sortDirection(key)})
+
+		if keys[len(keys)-1].column == "id" {
+			return keys
+		}
+	}
+
+	return append(keys, sortKey{"id", idDirection})
+}
+
+func (f Filters) orderBy() string {
+	return joinSortKeys(f.sortKeys("ASC"))
+}
+
+func joinSortKeys(keys []sortKey) string {
+	clauses := make([]string, len(keys))
+	for i, k := range keys {
+		clauses[i] = k.column + " " + k.direction
+	}
+
+	return strings.Join(clauses, ", ")
+}
+
+// Columns whose values a cursor records, every sort column before the id tiebreaker
+func (f Filters) cursorColumns() []string {
+	var columns []string
+
+	for _, k := range f.sortKeys("ASC") {
+		if k.column != "id" {
+			columns = append(columns, k.column)
+		}
+	}
+
+	return columns
+}
+
 func (f Filters) limit() int {
 	return f.PageSize
 }
@@ -57,6 +179,104 @@ func (f Filters) offset() int {
 	return (f.Page - 1) * f.PageSize
 }
 
+// Comparison and ordering continuing a keyset scan from the cursor, on the sort columns then id
+// Going backwards flips every direction, the caller reverses the rows afterwards
+func (f Filters) keyset(args *queryArgs) (where string, orderBy string) {
+	keys := f.sortKeys("")
+
+	// The tiebreaker follows the last column so a single direction stays a row comparison
+	if keys[len(keys)-1].direction == "" {
+		keys[len(keys)-1].direction = keys[len(keys)-2].direction
+	}
+
+	if f.Cursor != nil && f.Cursor.Prev {
+		for i := range keys {
+			keys[i].direction = map[string]string{"ASC": "DESC", "DESC": "ASC"}[keys[i].direction]
+		}
+	}
+
+	orderBy = joinSortKeys(keys)
+
+	if f.Cursor == nil {
+		return "", orderBy
+	}
+
+	columns := make([]string, len(keys))
+	placeholders := make([]string, len(keys))
+	uniform := true
+
+	for i, k := range keys {
+		columns[i] = k.column
+
+		if k.column == "id" {
+			placeholders[i] = args.add(f.Cursor.ID)
+		} else {
+			placeholders[i] = args.add(f.Cursor.Values[i])
+		}
+
+		uniform = uniform && k.direction == keys[0].direction
+	}
+
+	if len(keys) == 1 {
+		return fmt.Sprintf("id %s %s", seekOperator(keys[0].direction), placeholders[0]), orderBy
+	}
+
+	if uniform {
+		where = fmt.Sprintf("(%s) %s (%s)", strings.Join(columns, ", "), seekOperator(keys[0].direction), strings.Join(placeholders, ", "))
+		return where, orderBy
+	}
+
+	// Mixed directions cannot use a row comparison, so spell out each way of coming after the cursor:
+	// greater on the first column, or equal on it and greater on the second, and so on
+	alternatives := make([]string, len(keys))
+
+	for i, k := range keys {
+		var terms []string
+		for j := range i {
+			terms = append(terms, fmt.Sprintf("%s = %s", columns[j], placeholders[j]))
+		}
+		terms = append(terms, fmt.Sprintf("%s %s %s", k.column, seekOperator(k.direction), placeholders[i]))
+
+		alternatives[i] = "(" + strings.Join(terms, " AND ") + ")"
+	}
+
+	return "(" + strings.Join(alternatives, " OR ") + ")", orderBy
+}
+
+func seekOperator(direction string) string {
+	if direction == "DESC" {
+		return "<"
+	}
+
+	return ">"
+}
+
+// Cursors around a keyset page, fetched with one extra row to tell whether more follow in that direction
+// Rows must already be back in display order
+func (f Filters) cursorMetadata(count int, hasMore bool, position func(i int) ([]string, int64)) Metadata {
+	metadata := Metadata{PageSize: f.PageSize}
+
+	if count == 0 {
+		return metadata
+	}
+
+	backwards := f.Cursor != nil && f.Cursor.Prev
+
+	// Paging backwards means there are rows after, the page we came from
+	if hasMore || backwards {
+		values, id := position(count - 1)
+		metadata.NextCursor = Cursor{Sort: f.Sort, Values: values, ID: id}.Encode(f.CursorKey)
+	}
+
+	// Likewise any cursor paging forwards came from an earlier page
+	if (backwards && hasMore) || (!backwards && f.Cursor != nil) {
+		values, id := position(0)
+		metadata.PrevCursor = Cursor{Sort: f.Sort, Values: values, ID: id, Prev: true}.Encode(f.CursorKey)
+	}
+
+	return metadata
+}
+
 func calculateMetadata(totalRecords, page, pageSize int) Metadata {
 	if totalRecords == 0 {
 		return Metadata{}
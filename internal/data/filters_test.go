@@ -0,0 +1,58 @@
+package data
+
+import (
+	"testing"
+
+	"greenlight.honganhpham.net/internal/assert"
+	"greenlight.honganhpham.net/internal/validator"
+)
+
+var testSortSafeList = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+
+func TestFiltersOrderBy(t *testing.T) {
+	tests := []struct {
+		sort    string
+		orderBy string
+	}{
+		{"id", "id ASC"},
+		{"-year", "year DESC, id ASC"},
+		{"-year,title,runtime", "year DESC, title ASC, runtime ASC, id ASC"},
+		{"title,-id,year", "title ASC, id DESC"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			f := Filters{Sort: tt.sort, SortSafeList: testSortSafeList}
+			assert.Equal(t, f.orderBy(), tt.orderBy)
+		})
+	}
+}
+
+func TestFiltersUnsafeSortPanics(t *testing.T) {
+	defer func() {
+		assert.Equal(t, recover(), any("unsafe sort parameter: year; DROP TABLE movies"))
+	}()
+
+	Filters{Sort: "title,year; DROP TABLE movies", SortSafeList: testSortSafeList}.orderBy()
+}
+
+func TestValidateFiltersSort(t *testing.T) {
+	tests := []struct {
+		sort string
+		err  string
+	}{
+		{"-year,title,runtime", ""},
+		{"title,rating", "invalid sort value"},
+		{"title,", "invalid sort value"},
+		{"year,-year", "must not contain duplicate columns"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			v := validator.New()
+
+			ValidateFilters(v, Filters{Page: 1, PageSize: 20, Sort: tt.sort, SortSafeList: testSortSafeList})
+			assert.Equal(t, v.Errors["sort"], tt.err)
+		})
+	}
+}
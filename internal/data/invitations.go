@@ -0,0 +1,168 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.honganhpham.net/internal/validator"
+)
+
+// A single-use code letting one email address register while registration is invite-only
+type Invitation struct {
+	ID          int64       `json:"id"`
+	Code        string      `json:"-"` // Only ever sent to the invitee
+	Hash        []byte      `json:"-"`
+	Email       string      `json:"email"`
+	Permissions Permissions `json:"permissions"` // Granted on registration, on top of movies:read
+	InvitedBy   int64       `json:"invited_by"`
+	CreatedAt   time.Time   `json:"created_at"`
+	Expiry      time.Time   `json:"expiry"`
+	UsedAt      *time.Time  `json:"used_at"` // Pointer as the column is NULL until the invitation is used
+}
+
+type InvitationModel struct {
+	DB *sql.DB
+}
+
+type InvitationModelInterface interface {
+	New(invitation *Invitation, ttl time.Duration) error
+	GetAll() ([]*Invitation, error)
+	Consume(code, email string) (*Invitation, error)
+	Release(id int64) error
+}
+
+func ValidateInvitation(v *validator.Validator, invitation *Invitation, granted Permissions) {
+	ValidateEmail(v, invitation.Email)
+
+	v.Check(validator.Unique(invitation.Permissions), "permissions", "must not contain duplicate values")
+
+	// Nobody can invite someone with more permissions than they hold themselves
+	for _, code := range invitation.Permissions {
+		v.Check(granted.Include(code), "permissions", "you do not hold the permission "+code)
+	}
+}
+
+func ValidateInvitationCode(v *validator.Validator, code string) {
+	v.Check(code != "", "invitation", "must be provided")
+	v.Check(len(code) == 26, "invitation", "must be 26 bytes long")
+}
+
+// Generate the code and store the invitation, setting its ID, code and timestamps
+func (m InvitationModel) New(invitation *Invitation, ttl time.Duration) error {
+	token, err := generateToken(invitation.InvitedBy, ttl, "")
+	if err != nil {
+		return err
+	}
+
+	invitation.Code = token.Plaintext
+	invitation.Hash = token.Hash
+	invitation.Expiry = token.Expiry
+
+	query := `
+	INSERT INTO invitations (hash, email, permissions, invited_by, expiry)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at`
+
+	args := []any{invitation.Hash, invitation.Email, pq.Array(invitation.Permissions), invitation.InvitedBy, invitation.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&invitation.ID, &invitation.CreatedAt)
+}
+
+func (m InvitationModel) GetAll() ([]*Invitation, error) {
+	query := `
+	SELECT id, email, permissions, COALESCE(invited_by, 0), created_at, expiry, used_at
+	FROM invitations
+	ORDER BY id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	invitations := []*Invitation{}
+
+	for rows.Next() {
+		var invitation Invitation
+
+		err := rows.Scan(
+			&invitation.ID,
+			&invitation.Email,
+			pq.Array(&invitation.Permissions),
+			&invitation.InvitedBy,
+			&invitation.CreatedAt,
+			&invitation.Expiry,
+			&invitation.UsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		invitations = append(invitations, &invitation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+// Claim an unused, unexpired invitation issued to the email, so two registrations cannot both use it
+func (m InvitationModel) Consume(code, email string) (*Invitation, error) {
+	query := `
+	UPDATE invitations
+	SET used_at = NOW()
+	WHERE hash = $1 AND email = $2 AND used_at IS NULL AND expiry > NOW()
+	RETURNING id, email, permissions, COALESCE(invited_by, 0), created_at, expiry, used_at`
+
+	var invitation Invitation
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, HashTokenPlaintext(code), email).Scan(
+		&invitation.ID,
+		&invitation.Email,
+		pq.Array(&invitation.Permissions),
+		&invitation.InvitedBy,
+		&invitation.CreatedAt,
+		&invitation.Expiry,
+		&invitation.UsedAt,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &invitation, nil
+}
+
+// Make a consumed invitation usable again, for when the registration fails after claiming it
+func (m InvitationModel) Release(id int64) error {
+	query := `
+	UPDATE invitations
+	SET used_at = NULL
+	WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
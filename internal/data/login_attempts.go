@@ -0,0 +1,159 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Failed password attempts for a single email address
+type LoginAttempt struct {
+	Email        string
+	FailedCount  int
+	LastFailedAt time.Time
+	LockedUntil  time.Time
+}
+
+// Report whether the account is still locked at the given time
+func (a *LoginAttempt) Locked(now time.Time) bool {
+	return now.Before(a.LockedUntil)
+}
+
+type LoginAttemptModel struct {
+	DB *sql.DB
+}
+
+type LoginAttemptModelInterface interface {
+	Get(email string) (*LoginAttempt, error)
+	RecordFailure(email string, since time.Time) (*LoginAttempt, error)
+	Lock(email string, until time.Time) error
+	Reset(email string) error
+	DeleteStale(before time.Time) (int64, error)
+}
+
+// Escalate the lockout by doubling the base duration for every failure past the threshold, up to max
+func LockoutDuration(failedCount, threshold int, base, max time.Duration) time.Duration {
+	if failedCount < threshold {
+		return 0
+	}
+
+	duration := base
+	for i := threshold; i < failedCount && duration < max; i++ {
+		duration *= 2
+	}
+
+	if duration > max {
+		return max
+	}
+
+	return duration
+}
+
+func (m LoginAttemptModel) Get(email string) (*LoginAttempt, error) {
+	query := `
+	SELECT email, failed_count, last_failed_at, locked_until
+	FROM login_attempts
+	WHERE email = $1
+	`
+
+	var attempt LoginAttempt
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(
+		&attempt.Email,
+		&attempt.FailedCount,
+		&attempt.LastFailedAt,
+		&attempt.LockedUntil,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &attempt, nil
+}
+
+// Increment the failure counter, creating the row on the first failure
+// A previous failure before since no longer counts, so the counter starts over
+func (m LoginAttemptModel) RecordFailure(email string, since time.Time) (*LoginAttempt, error) {
+	query := `
+	INSERT INTO login_attempts (email, failed_count, last_failed_at)
+	VALUES ($1, 1, NOW())
+	ON CONFLICT (email) DO UPDATE
+	SET failed_count = CASE WHEN login_attempts.last_failed_at < $2 THEN 1 ELSE login_attempts.failed_count + 1 END,
+		last_failed_at = NOW()
+	RETURNING email, failed_count, last_failed_at, locked_until
+	`
+
+	var attempt LoginAttempt
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, email, since).Scan(
+		&attempt.Email,
+		&attempt.FailedCount,
+		&attempt.LastFailedAt,
+		&attempt.LockedUntil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &attempt, nil
+}
+
+func (m LoginAttemptModel) Lock(email string, until time.Time) error {
+	query := `
+	UPDATE login_attempts
+	SET locked_until = $2
+	WHERE email = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, email, until)
+	return err
+}
+
+// Forget previous failures after a successful login
+func (m LoginAttemptModel) Reset(email string) error {
+	query := `
+	DELETE FROM login_attempts
+	WHERE email = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, email)
+	return err
+}
+
+// Delete rows whose last failure is before the given time and which are no longer locked
+// Rows are created for any email, so without this spraying unknown addresses grows the table forever
+func (m LoginAttemptModel) DeleteStale(before time.Time) (int64, error) {
+	query := `
+	DELETE FROM login_attempts
+	WHERE last_failed_at < $1 AND locked_until <= NOW()
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,43 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"greenlight.honganhpham.net/internal/assert"
+)
+
+func TestLockoutDuration(t *testing.T) {
+	tests := []struct {
+		name        string
+		failedCount int
+		expected    time.Duration
+	}{
+		{
+			name:        "Below Threshold",
+			failedCount: 4,
+			expected:    0,
+		},
+		{
+			name:        "At Threshold",
+			failedCount: 5,
+			expected:    time.Minute,
+		},
+		{
+			name:        "Escalates",
+			failedCount: 7,
+			expected:    4 * time.Minute,
+		},
+		{
+			name:        "Capped",
+			failedCount: 50,
+			expected:    time.Hour,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, LockoutDuration(tt.failedCount, 5, time.Minute, time.Hour), tt.expected)
+		})
+	}
+}
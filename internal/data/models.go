@@ -11,16 +11,34 @@ var (
 )
 
 type Models struct {
-	Movies MovieModelInterface
-	Users  UserModelInterface
-	Token  TokenModelInterface
+	Movies         MovieModelInterface
+	Users          UserModelInterface
+	Token          TokenModelInterface
+	Permissions    PermissionModelInterface
+	APIKeys        APIKeyModelInterface
+	LoginAttempts  LoginAttemptModelInterface
+	TwoFactor      TwoFactorModelInterface
+	OAuth          OAuthModelInterface
+	Audit          AuditModelInterface
+	Invitations    InvitationModelInterface
+	Hasher         PasswordHasher // Used for new password hashes, existing ones are matched by their own format
+	PasswordPolicy PasswordPolicy // Applied to new passwords only
 }
 
-func NewModels(db *sql.DB) *Models {
+func NewModels(db *sql.DB, hasher PasswordHasher, policy PasswordPolicy, auditKey []byte) *Models {
 	// Return pointer type to ensure we are working with the same instance
 	return &Models{
-		Movies: MovieModel{DB: db},
-		Users:  UserModel{DB: db},
-		Token:  TokenModel{DB: db},
+		Movies:         MovieModel{DB: db},
+		Users:          UserModel{DB: db},
+		Token:          TokenModel{DB: db},
+		Permissions:    PermissionModel{DB: db},
+		APIKeys:        APIKeyModel{DB: db},
+		LoginAttempts:  LoginAttemptModel{DB: db},
+		TwoFactor:      TwoFactorModel{DB: db},
+		OAuth:          OAuthModel{DB: db},
+		Audit:          AuditModel{DB: db, Key: auditKey},
+		Invitations:    InvitationModel{DB: db},
+		Hasher:         hasher,
+		PasswordPolicy: policy,
 	}
 }
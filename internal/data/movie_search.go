@@ -0,0 +1,130 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lib/pq"
+	"greenlight.honganhpham.net/internal/validator"
+)
+
+// Text search configurations a search may pick, each backed by an index on to_tsvector(config, title)
+var SearchConfigs = []string{"english", "simple"}
+
+type MovieSearch struct {
+	Query  string // websearch_to_tsquery syntax: quoted phrases, or, -excluded
+	Config string
+	Prefix bool // Type-ahead: match the words as typed so far, the last one as a prefix
+}
+
+// A movie with how well it matched and its title with the matches highlighted
+type MovieSearchResult struct {
+	*Movie
+	Rank     float64 `json:"rank"`
+	Headline string  `json:"headline"`
+}
+
+func ValidateMovieSearch(v *validator.Validator, s MovieSearch) {
+	v.Check(s.Query != "", "q", "must be provided")
+	v.Check(len(s.Query) <= 500, "q", "must not be more than 500 bytes long")
+	v.Check(validator.PermittedValue(s.Config, SearchConfigs...), "config", "must be english or simple")
+
+	if s.Prefix && s.Query != "" {
+		v.Check(prefixQuery(s.Query) != "", "q", "must contain at least one word")
+	}
+}
+
+// Spliced into the query so the planner can match the expression indexes, which a parameter would hide
+func (s MovieSearch) config() string {
+	for _, safeValue := range SearchConfigs {
+		if s.Config == safeValue {
+			return s.Config
+		}
+	}
+
+	// failsafe to help us stop SQL injection attacks
+	panic("unsafe search config: " + s.Config)
+}
+
+// Search syntax is not useful while typing, so keep the words alone, AND them and let the last one be a prefix
+// e.g. `black pan` becomes `black & pan:*`
+func prefixQuery(q string) string {
+	words := strings.FieldsFunc(q, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	if len(words) == 0 {
+		return ""
+	}
+
+	return strings.Join(words, " & ") + ":*"
+}
+
+func (s MovieSearch) tsquery(args *queryArgs) string {
+	if s.Prefix {
+		return fmt.Sprintf("to_tsquery('%s', %s)", s.config(), args.add(prefixQuery(s.Query)))
+	}
+
+	return fmt.Sprintf("websearch_to_tsquery('%s', %s)", s.config(), args.add(s.Query))
+}
+
+// Rank matching movies with ts_rank_cd, best first unless another sort is asked for
+func (m MovieModel) Search(search MovieSearch, filters Filters) ([]*MovieSearchResult, Metadata, error) {
+	var args queryArgs
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version,
+			ts_rank_cd(to_tsvector('%[1]s', title), query) AS rank,
+			ts_headline('%[1]s', title, query) AS headline
+		FROM movies, %[2]s AS query
+		WHERE to_tsvector('%[1]s', title) @@ query
+		ORDER BY %[3]s
+		LIMIT %[4]s OFFSET %[5]s
+		`, search.config(), search.tsquery(&args), filters.orderBy(), args.add(filters.limit()), args.add(filters.offset()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	results := []*MovieSearchResult{}
+
+	for rows.Next() {
+		result := MovieSearchResult{Movie: &Movie{}}
+
+		err := rows.Scan(
+			&totalRecords,
+			&result.ID,
+			&result.CreatedAt,
+			&result.Title,
+			&result.Year,
+			&result.Runtime,
+			pq.Array(&result.Genres),
+			&result.Version,
+			&result.Rank,
+			&result.Headline,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		results = append(results, &result)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return results, metadata, nil
+}
@@ -0,0 +1,41 @@
+package data
+
+import (
+	"testing"
+
+	"greenlight.honganhpham.net/internal/assert"
+)
+
+func TestPrefixQuery(t *testing.T) {
+	tests := []struct {
+		q        string
+		expected string
+	}{
+		{"black pan", "black & pan:*"},
+		{"  Black   ", "Black:*"},
+		{`"black panther" -wakanda`, "black & panther & wakanda:*"},
+		{"it's a:b|c!", "it & s & a & b & c:*"},
+		{"&|!():*", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.q, func(t *testing.T) {
+			assert.Equal(t, prefixQuery(tt.q), tt.expected)
+		})
+	}
+}
+
+func TestMovieSearchTSQuery(t *testing.T) {
+	var args queryArgs
+
+	assert.Equal(t, MovieSearch{Query: "black -panther", Config: "english"}.tsquery(&args), "websearch_to_tsquery('english', $1)")
+	assert.Equal(t, MovieSearch{Query: "black pan", Config: "simple", Prefix: true}.tsquery(&args), "to_tsquery('simple', $2)")
+	assert.Equal(t, args[0], any("black -panther"))
+	assert.Equal(t, args[1], any("black & pan:*"))
+
+	defer func() {
+		assert.Equal(t, recover(), any("unsafe search config: english'); DROP TABLE movies; --"))
+	}()
+
+	MovieSearch{Query: "black", Config: "english'); DROP TABLE movies; --"}.tsquery(&args)
+}
@@ -5,6 +5,9 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
@@ -19,6 +22,7 @@ type Movie struct {
 	Runtime   Runtime   `json:"runtime,omitempty,string"`
 	Genres    []string  `json:"genres,omitempty"`
 	Version   int32     `json:"version"`
+	CreatedBy int64     `json:"-"` // Zero when the author deleted their account
 }
 
 type MovieModel struct {
@@ -28,9 +32,11 @@ type MovieModel struct {
 type MovieModelInterface interface {
 	Insert(movie *Movie) error
 	GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error)
+	Search(search MovieSearch, filters Filters) ([]*MovieSearchResult, Metadata, error)
 	Get(id int64) (*Movie, error)
 	Update(movie *Movie) error
 	Delete(id int64) error
+	GetAllForUser(userID int64) ([]*Movie, error)
 }
 
 func ValidateMovie(v *validator.Validator, movie *Movie) {
@@ -50,8 +56,8 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 
 func (m MovieModel) Insert(movie *Movie) error {
 	query := `
-	INSERT INTO movies (title, year, runtime, genres)
-	VALUES ($1, $2, $3, $4)
+	INSERT INTO movies (title, year, runtime, genres, user_id)
+	VALUES ($1, $2, $3, $4, NULLIF($5::bigint, 0))
 	RETURNING id, created_at, version
 	`
 
@@ -62,7 +68,7 @@ func (m MovieModel) Insert(movie *Movie) error {
 	defer cancel()
 
 	// pq.Array allows decoding Go slices to PostgreSQL array columns
-	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.CreatedBy}
 
 	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
 }
@@ -192,19 +198,26 @@ func (m MovieModel) GetAll(
 	title string,
 	genres []string,
 	filters Filters) ([]*Movie, Metadata, error) {
+	if filters.CursorMode {
+		return m.getAllByCursor(title, genres, filters)
+	}
+
+	var args queryArgs
+
+	where := movieConditions(title, genres, filters, &args)
+
 	query := fmt.Sprintf(`
 		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
 		FROM movies
-		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-		AND (genres @> $2 OR $2 = '{}')
-		ORDER BY %s %s, id ASC
-		LIMIT $3 OFFSET $4
-		`, filters.sortColumn(), filters.sortDirection())
+		%s
+		ORDER BY %s
+		LIMIT %s OFFSET %s
+		`, where, filters.orderBy(), args.add(filters.limit()), args.add(filters.offset()))
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, title, pq.Array(genres), filters.limit(), filters.offset())
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 
 	if err != nil {
 		return nil, Metadata{}, err
@@ -246,3 +259,201 @@ func (m MovieModel) GetAll(
 
 	return movies, metadata, nil
 }
+
+// Keyset pagination: seek past the cursor instead of counting and skipping rows
+// Stays fast on deep pages and never repeats or skips rows inserted mid-scroll
+func (m MovieModel) getAllByCursor(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+	var args queryArgs
+
+	seek, orderBy := filters.keyset(&args)
+
+	var extra []string
+	if seek != "" {
+		extra = append(extra, seek)
+	}
+
+	where := movieConditions(title, genres, filters, &args, extra...)
+
+	// One extra row tells whether there is another page
+	query := fmt.Sprintf(`
+		SELECT id, created_at, title, year, runtime, genres, version
+		FROM movies
+		%s
+		ORDER BY %s
+		LIMIT %s
+		`, where, orderBy, args.add(filters.limit()+1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	hasMore := len(movies) > filters.limit()
+	if hasMore {
+		movies = movies[:filters.limit()]
+	}
+
+	// Going backwards scanned in reverse order
+	if filters.Cursor != nil && filters.Cursor.Prev {
+		slices.Reverse(movies)
+	}
+
+	columns := filters.cursorColumns()
+
+	metadata := filters.cursorMetadata(len(movies), hasMore, func(i int) ([]string, int64) {
+		values := make([]string, len(columns))
+		for j, column := range columns {
+			values[j] = movies[i].sortValue(column)
+		}
+		return values, movies[i].ID
+	})
+
+	return movies, metadata, nil
+}
+
+// Build the WHERE clause of a listing from the filters, with every value passed as a parameter
+func movieConditions(title string, genres []string, f Filters, args *queryArgs, extra ...string) string {
+	conditions := extra
+
+	if title != "" {
+		conditions = append(conditions, fmt.Sprintf("to_tsvector('simple', title) @@ plainto_tsquery('simple', %s)", args.add(title)))
+	}
+
+	if len(genres) > 0 {
+		genresArg := args.add(pq.Array(genres))
+
+		switch f.GenresMode {
+		case GenresModeAny:
+			conditions = append(conditions, "genres && "+genresArg)
+		case GenresModeNone:
+			conditions = append(conditions, "NOT genres && "+genresArg)
+		default:
+			conditions = append(conditions, "genres @> "+genresArg)
+		}
+	}
+
+	if f.Year.Min != nil {
+		conditions = append(conditions, "year >= "+args.add(*f.Year.Min))
+	}
+
+	if f.Year.Max != nil {
+		conditions = append(conditions, "year <= "+args.add(*f.Year.Max))
+	}
+
+	if f.Runtime.Min != nil {
+		conditions = append(conditions, "runtime >= "+args.add(*f.Runtime.Min))
+	}
+
+	if f.Runtime.Max != nil {
+		conditions = append(conditions, "runtime <= "+args.add(*f.Runtime.Max))
+	}
+
+	if f.Created.After != nil {
+		conditions = append(conditions, "created_at > "+args.add(*f.Created.After))
+	}
+
+	if f.Created.Before != nil {
+		conditions = append(conditions, "created_at < "+args.add(*f.Created.Before))
+	}
+
+	if f.Expression != nil {
+		conditions = append(conditions, f.Expression.sql(f.FilterFields, args))
+	}
+
+	if len(conditions) == 0 {
+		return ""
+	}
+
+	return "WHERE " + strings.Join(conditions, "\n\t\tAND ")
+}
+
+// Value of the column the movie is sorted on, as stored in a cursor
+func (movie *Movie) sortValue(column string) string {
+	switch column {
+	case "title":
+		return movie.Title
+	case "year":
+		return strconv.Itoa(int(movie.Year))
+	case "runtime":
+		return strconv.Itoa(int(movie.Runtime))
+	default:
+		return ""
+	}
+}
+
+// Every movie the user authored, used for the personal data export
+func (m MovieModel) GetAllForUser(userID int64) ([]*Movie, error) {
+	query := `
+		SELECT id, created_at, title, year, runtime, genres, version
+		FROM movies
+		WHERE user_id = $1
+		ORDER BY id
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+		movie := Movie{CreatedBy: userID}
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
@@ -0,0 +1,57 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"greenlight.honganhpham.net/internal/assert"
+)
+
+func TestMovieConditions(t *testing.T) {
+	year, runtime := 1990, 150
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		title   string
+		genres  []string
+		filters Filters
+		where   string
+		args    int
+	}{
+		{"No Filters", "", nil, Filters{}, "", 0},
+		{"Title", "panther", nil, Filters{}, "WHERE to_tsvector('simple', title) @@ plainto_tsquery('simple', $1)", 1},
+		{"All Genres", "", []string{"drama"}, Filters{GenresMode: GenresModeAll}, "WHERE genres @> $1", 1},
+		{"Any Genre", "", []string{"drama"}, Filters{GenresMode: GenresModeAny}, "WHERE genres && $1", 1},
+		{"No Genre", "", []string{"drama"}, Filters{GenresMode: GenresModeNone}, "WHERE NOT genres && $1", 1},
+		{
+			"Ranges",
+			"",
+			nil,
+			Filters{Year: IntRange{Min: &year}, Runtime: IntRange{Max: &runtime}, Created: TimeRange{After: &created, Before: &created}},
+			"WHERE year >= $1\n\t\tAND runtime <= $2\n\t\tAND created_at > $3\n\t\tAND created_at < $4",
+			4,
+		},
+		{
+			"Expression",
+			"",
+			[]string{"drama"},
+			Filters{
+				FilterFields: FilterFields{"year": {Column: "year", Type: FilterInt}},
+				Expression:   &FilterNot{Expr: &FilterComparison{Field: "year", Op: "<", Value: int64(1990)}},
+			},
+			"WHERE genres @> $1\n\t\tAND NOT year < $2",
+			2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var args queryArgs
+
+			where := movieConditions(tt.title, tt.genres, tt.filters, &args)
+			assert.Equal(t, where, tt.where)
+			assert.Equal(t, len(args), tt.args)
+		})
+	}
+}
@@ -0,0 +1,341 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.honganhpham.net/internal/validator"
+)
+
+// Access tokens issued to OAuth clients share the tokens table with our own tokens
+const ScopeOAuthAccess = "oauth-access"
+
+type OAuthClient struct {
+	ID           int64       `json:"-"`
+	ClientID     string      `json:"client_id"`
+	Secret       string      `json:"client_secret,omitempty"` // Only returned once on registration
+	SecretHash   []byte      `json:"-"`
+	UserID       int64       `json:"-"`
+	Name         string      `json:"name"`
+	RedirectURIs []string    `json:"redirect_uris"`
+	Scopes       Permissions `json:"scopes"`
+	CreatedAt    time.Time   `json:"created_at"`
+}
+
+// Confidential clients can keep a secret, public ones cannot and must use PKCE
+func (c *OAuthClient) Confidential() bool {
+	return c.SecretHash != nil
+}
+
+func (c *OAuthClient) SecretMatches(secret string) bool {
+	if !c.Confidential() {
+		return false
+	}
+	return subtle.ConstantTimeCompare(HashTokenPlaintext(secret), c.SecretHash) == 1
+}
+
+func (c *OAuthClient) HasRedirectURI(uri string) bool {
+	for _, registered := range c.RedirectURIs {
+		if registered == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorization code waiting to be exchanged for an access token
+type OAuthCode struct {
+	Plaintext     string
+	Hash          []byte
+	ClientID      int64
+	UserID        int64
+	RedirectURI   string
+	Scopes        Permissions
+	CodeChallenge string
+	Expiry        time.Time
+}
+
+// What an access token grants, as reported by introspection
+type OAuthAccess struct {
+	UserID   int64
+	ClientID string
+	Scopes   Permissions
+	Expiry   time.Time
+}
+
+type OAuthModel struct {
+	DB *sql.DB
+}
+
+type OAuthModelInterface interface {
+	NewClient(client *OAuthClient, confidential bool) error
+	GetClient(clientID string) (*OAuthClient, error)
+	NewCode(code *OAuthCode, ttl time.Duration) error
+	ConsumeCode(codePlaintext string) (*OAuthCode, error)
+	NewAccessToken(userID, clientID int64, scopes Permissions, ttl time.Duration) (*Token, error)
+	GetAccess(tokenPlaintext string) (*OAuthAccess, error)
+}
+
+func ValidateOAuthClient(v *validator.Validator, client *OAuthClient, granted Permissions) {
+	v.Check(client.Name != "", "name", "must be provided")
+	v.Check(len(client.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(len(client.Scopes) > 0, "scopes", "must contain at least 1 scope")
+	v.Check(validator.Unique(client.Scopes), "scopes", "must not contain duplicate values")
+	v.Check(len(client.RedirectURIs) <= 10, "redirect_uris", "must not contain more than 10 values")
+
+	// A client can never do more than its owner
+	for _, code := range client.Scopes {
+		v.Check(granted.Include(code), "scopes", "you do not hold the permission "+code)
+	}
+
+	for _, uri := range client.RedirectURIs {
+		v.Check(validRedirectURI(uri), "redirect_uris", "must be absolute https URLs without a fragment, or http on localhost")
+	}
+}
+
+func validRedirectURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || u.Fragment != "" || u.Host == "" {
+		return false
+	}
+
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		return u.Hostname() == "localhost" || u.Hostname() == "127.0.0.1"
+	default:
+		return false
+	}
+}
+
+// Space separated scopes as defined in RFC 6749 section 3.3, each one a permission code
+func ParseOAuthScopes(scope string) Permissions {
+	return Permissions(strings.Fields(scope))
+}
+
+// Check a PKCE verifier against the S256 challenge sent with the authorization request
+func VerifyCodeChallenge(verifier, challenge string) bool {
+	// RFC 7636 section 4.1
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+func randomString(n int) (string, error) {
+	randomBytes := make([]byte, n)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)), nil
+}
+
+// Generate the client ID and, for confidential clients, a secret before storing the client
+func (m OAuthModel) NewClient(client *OAuthClient, confidential bool) error {
+	clientID, err := randomString(16)
+	if err != nil {
+		return err
+	}
+
+	client.ClientID = clientID
+
+	if confidential {
+		client.Secret, err = randomString(32)
+		if err != nil {
+			return err
+		}
+		client.SecretHash = HashTokenPlaintext(client.Secret)
+	}
+
+	query := `
+	INSERT INTO oauth_clients (client_id, secret_hash, user_id, name, redirect_uris, scopes)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at`
+
+	args := []any{client.ClientID, client.SecretHash, client.UserID, client.Name, pq.Array(client.RedirectURIs), pq.Array(client.Scopes)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&client.ID, &client.CreatedAt)
+}
+
+func (m OAuthModel) GetClient(clientID string) (*OAuthClient, error) {
+	query := `
+	SELECT id, client_id, secret_hash, user_id, name, redirect_uris, scopes, created_at
+	FROM oauth_clients
+	WHERE client_id = $1
+	`
+
+	var client OAuthClient
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, clientID).Scan(
+		&client.ID,
+		&client.ClientID,
+		&client.SecretHash,
+		&client.UserID,
+		&client.Name,
+		pq.Array(&client.RedirectURIs),
+		pq.Array(&client.Scopes),
+		&client.CreatedAt,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &client, nil
+}
+
+// Codes are generated and hashed exactly like tokens, only stored in their own table
+func (m OAuthModel) NewCode(code *OAuthCode, ttl time.Duration) error {
+	token, err := generateToken(code.UserID, ttl, "")
+	if err != nil {
+		return err
+	}
+
+	code.Plaintext = token.Plaintext
+	code.Hash = token.Hash
+	code.Expiry = token.Expiry
+
+	query := `
+	INSERT INTO oauth_codes (hash, client_id, user_id, redirect_uri, scopes, code_challenge, expiry)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	args := []any{code.Hash, code.ClientID, code.UserID, code.RedirectURI, pq.Array(code.Scopes), code.CodeChallenge, code.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Delete and return an unexpired code, so it can never be exchanged twice
+func (m OAuthModel) ConsumeCode(codePlaintext string) (*OAuthCode, error) {
+	query := `
+	DELETE FROM oauth_codes
+	WHERE hash = $1
+	RETURNING client_id, user_id, redirect_uri, scopes, code_challenge, expiry
+	`
+
+	code := OAuthCode{
+		Plaintext: codePlaintext,
+		Hash:      HashTokenPlaintext(codePlaintext),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, code.Hash).Scan(
+		&code.ClientID,
+		&code.UserID,
+		&code.RedirectURI,
+		pq.Array(&code.Scopes),
+		&code.CodeChallenge,
+		&code.Expiry,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if time.Now().After(code.Expiry) {
+		return nil, ErrRecordNotFound
+	}
+
+	return &code, nil
+}
+
+func (m OAuthModel) NewAccessToken(userID, clientID int64, scopes Permissions, ttl time.Duration) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeOAuthAccess)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+	INSERT INTO tokens (hash, user_id, expiry, scope, client_id, scopes)
+	VALUES ($1, $2, $3, $4, $5, $6)`
+
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope, clientID, pq.Array(scopes)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// Look up a live access token, ignoring tokens of deleted accounts
+func (m OAuthModel) GetAccess(tokenPlaintext string) (*OAuthAccess, error) {
+	query := `
+	SELECT t.user_id, c.client_id, t.scopes, t.expiry
+	FROM tokens AS t
+	INNER JOIN oauth_clients AS c
+	ON c.id = t.client_id
+	INNER JOIN users AS u
+	ON u.id = t.user_id
+	WHERE t.hash = $1
+	AND t.scope = $2
+	AND t.expiry > $3
+	AND u.deleted_at IS NULL
+	`
+
+	var access OAuthAccess
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, HashTokenPlaintext(tokenPlaintext), ScopeOAuthAccess, time.Now()).Scan(
+		&access.UserID,
+		&access.ClientID,
+		pq.Array(&access.Scopes),
+		&access.Expiry,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &access, nil
+}
@@ -0,0 +1,51 @@
+package data
+
+import (
+	"testing"
+
+	"greenlight.honganhpham.net/internal/assert"
+	"greenlight.honganhpham.net/internal/validator"
+)
+
+func TestVerifyCodeChallenge(t *testing.T) {
+	// RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	assert.Equal(t, VerifyCodeChallenge(verifier, challenge), true)
+	assert.Equal(t, VerifyCodeChallenge(verifier+"x", challenge), false)
+
+	// Too short to carry enough entropy, even if it matches
+	assert.Equal(t, VerifyCodeChallenge("short", "short"), false)
+}
+
+func TestValidateOAuthClient(t *testing.T) {
+	granted := Permissions{PermissionMoviesRead}
+
+	tests := []struct {
+		name  string
+		uris  []string
+		valid bool
+	}{
+		{name: "HTTPS", uris: []string{"https://app.example.com/callback"}, valid: true},
+		{name: "Localhost", uris: []string{"http://localhost:8080/callback"}, valid: true},
+		{name: "Plain HTTP", uris: []string{"http://app.example.com/callback"}, valid: false},
+		{name: "Fragment", uris: []string{"https://app.example.com/callback#x"}, valid: false},
+		{name: "Relative", uris: []string{"/callback"}, valid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+
+			client := &OAuthClient{Name: "Client", RedirectURIs: tt.uris, Scopes: Permissions{PermissionMoviesRead}}
+			ValidateOAuthClient(v, client, granted)
+
+			assert.Equal(t, v.Valid(), tt.valid)
+		})
+	}
+
+	v := validator.New()
+	ValidateOAuthClient(v, &OAuthClient{Name: "Client", Scopes: Permissions{PermissionMoviesWrite}}, granted)
+	assert.Equal(t, v.Valid(), false)
+}
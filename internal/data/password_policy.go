@@ -0,0 +1,121 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"greenlight.honganhpham.net/internal/validator"
+)
+
+//go:embed "common_passwords.txt"
+var commonPasswordsFile string
+
+var commonPasswords = parseCommonPasswords(commonPasswordsFile)
+
+// Rules only applied to new passwords, so existing users can still log in after the policy tightens
+type PasswordPolicy struct {
+	MinLength      int
+	RejectCommon   bool
+	RejectPersonal bool               // Passwords containing the user's name or email
+	Breached       *BreachedPasswords // nil to skip the breach lookup
+}
+
+// Local copy of a breach corpus split the same way as the HIBP range API
+// One file per 5 character SHA-1 prefix, holding "SUFFIX:COUNT" lines
+type BreachedPasswords struct {
+	Dir string
+}
+
+func (b *BreachedPasswords) Contains(plaintext string) (bool, error) {
+	sum := sha1.Sum([]byte(plaintext))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	f, err := os.Open(filepath.Join(b.Dir, hash[:5]))
+	if err != nil {
+		// No file means no breached password shares the prefix
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	defer f.Close()
+
+	suffix := []byte(hash[5:])
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+
+		found, _, _ := bytes.Cut(line, []byte(":"))
+		if bytes.EqualFold(found, suffix) {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
+
+// Validate a password being set against the policy, on top of the checks every password goes through
+func ValidateNewPassword(v *validator.Validator, password string, user *User, p PasswordPolicy) {
+	ValidatePasswordPlaintext(v, password)
+
+	if !v.Valid() {
+		return
+	}
+
+	v.Check(len(password) >= p.MinLength, "password", "must be at least "+strconv.Itoa(p.MinLength)+" bytes long")
+
+	if p.RejectCommon {
+		v.Check(!commonPasswords[strings.ToLower(password)], "password", "is too common")
+	}
+
+	if p.RejectPersonal && user != nil {
+		v.Check(!containsPersonalInfo(password, user), "password", "must not contain your name or email address")
+	}
+
+	if p.Breached != nil && v.Valid() {
+		// Fail open, an unreadable breach list must not stop anyone from signing up
+		breached, err := p.Breached.Contains(password)
+		v.Check(err != nil || !breached, "password", "has appeared in a data breach, please choose another")
+	}
+}
+
+func containsPersonalInfo(password string, user *User) bool {
+	password = strings.ToLower(password)
+
+	local, _, _ := strings.Cut(user.Email, "@")
+	parts := append(strings.Fields(user.Name), local)
+
+	for _, part := range parts {
+		// Skip initials and the like, which would reject far too much
+		if len(part) >= 3 && strings.Contains(password, strings.ToLower(part)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseCommonPasswords(file string) map[string]bool {
+	passwords := make(map[string]bool)
+
+	for _, line := range strings.Split(file, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords[strings.ToLower(line)] = true
+	}
+
+	return passwords
+}
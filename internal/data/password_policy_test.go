@@ -0,0 +1,71 @@
+package data
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"greenlight.honganhpham.net/internal/assert"
+	"greenlight.honganhpham.net/internal/validator"
+)
+
+func TestBreachedPasswords(t *testing.T) {
+	dir := t.TempDir()
+
+	// SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8, split like the HIBP range files
+	err := os.WriteFile(filepath.Join(dir, "5BAA6"), []byte("0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n1e4c9b93f3f0682250b6cf8331b7ee68fd8:3730471\r\n"), 0o600)
+	assert.NilError(t, err)
+
+	breached := &BreachedPasswords{Dir: dir}
+
+	tests := []struct {
+		password string
+		found    bool
+	}{
+		{"password", true},
+		{"Password", false}, // No file for its prefix
+		{"correct horse", false},
+	}
+
+	for _, tt := range tests {
+		found, err := breached.Contains(tt.password)
+		assert.NilError(t, err)
+		assert.Equal(t, found, tt.found)
+	}
+}
+
+func TestValidateNewPassword(t *testing.T) {
+	dir := t.TempDir()
+	// SHA-1 of "tr0ub4dor&3" is 281397B1F7880ADE0F53530A55D9AF0210B9AD7B
+	err := os.WriteFile(filepath.Join(dir, "28139"), []byte("7B1F7880ADE0F53530A55D9AF0210B9AD7B:42\n"), 0o600)
+	assert.NilError(t, err)
+
+	user := &User{Name: "Jane Q Doe", Email: "jdoe42@example.com"}
+
+	tests := []struct {
+		name     string
+		policy   PasswordPolicy
+		password string
+		valid    bool
+	}{
+		{"Valid", PasswordPolicy{MinLength: 8, RejectCommon: true, RejectPersonal: true}, "pa55word", true},
+		{"Too Short", PasswordPolicy{MinLength: 8}, "short", false},
+		{"Below Policy Length", PasswordPolicy{MinLength: 12}, "pa55word", false},
+		{"Common", PasswordPolicy{MinLength: 8, RejectCommon: true}, "Password123", false},
+		{"Common Allowed", PasswordPolicy{MinLength: 8}, "Password123", true},
+		{"Contains Name", PasswordPolicy{MinLength: 8, RejectPersonal: true}, "iamJANE2024", false},
+		{"Contains Email", PasswordPolicy{MinLength: 8, RejectPersonal: true}, "xxjdoe42xx", false},
+		{"Initial Ignored", PasswordPolicy{MinLength: 8, RejectPersonal: true}, "qwq-pa55word", true},
+		{"Breached", PasswordPolicy{MinLength: 8, Breached: &BreachedPasswords{Dir: dir}}, "tr0ub4dor&3", false},
+		{"Not Breached", PasswordPolicy{MinLength: 8, Breached: &BreachedPasswords{Dir: dir}}, "pa55word", true},
+		{"Missing Breach Files", PasswordPolicy{MinLength: 8, Breached: &BreachedPasswords{Dir: filepath.Join(dir, "missing")}}, "pa55word", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			ValidateNewPassword(v, tt.password, user, tt.policy)
+			assert.Equal(t, v.Valid(), tt.valid)
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package data
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrUnknownPasswordHash = errors.New("unknown password hash format")
+
+// Hashes stored in password_hash, each algorithm recognising its own prefix so they can coexist
+type PasswordHasher interface {
+	Hash(plaintext string) ([]byte, error)
+	Matches(hash []byte, plaintext string) (bool, error)
+	Handles(hash []byte) bool
+	// Report whether a hash this hasher handles was created with weaker parameters
+	Outdated(hash []byte) bool
+}
+
+// Every hasher that may have produced a stored hash, in the order they are tried
+var passwordHashers = []PasswordHasher{BcryptHasher{}, Argon2idHasher{}}
+
+func hasherFor(hash []byte) (PasswordHasher, error) {
+	for _, h := range passwordHashers {
+		if h.Handles(hash) {
+			return h, nil
+		}
+	}
+	return nil, ErrUnknownPasswordHash
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(plaintext string) ([]byte, error) {
+	// Return in format $2b$[cost]$[22-character salt][31-character hash]
+	return bcrypt.GenerateFromPassword([]byte(plaintext), h.Cost)
+}
+
+func (h BcryptHasher) Matches(hash []byte, plaintext string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword(hash, []byte(plaintext))
+
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (h BcryptHasher) Handles(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte("$2"))
+}
+
+func (h BcryptHasher) Outdated(hash []byte) bool {
+	cost, err := bcrypt.Cost(hash)
+	return err != nil || cost < h.Cost
+}
+
+// Memory-hard alternative to bcrypt, stored in the PHC string format
+// e.g. $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+type Argon2idHasher struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+}
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// Base64 without padding as used by the reference implementation
+var argon2Encoding = base64.RawStdEncoding
+
+func (h Argon2idHasher) Hash(plaintext string) ([]byte, error) {
+	salt := make([]byte, argon2SaltLength)
+
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey([]byte(plaintext), salt, h.Iterations, h.Memory, h.Parallelism, argon2KeyLength)
+
+	encoded := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		argon2Encoding.EncodeToString(salt), argon2Encoding.EncodeToString(key))
+
+	return []byte(encoded), nil
+}
+
+func (h Argon2idHasher) Matches(hash []byte, plaintext string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+
+	// Recompute with the parameters the hash was created with, not the current ones
+	other := argon2.IDKey([]byte(plaintext), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (h Argon2idHasher) Handles(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte("$argon2id$"))
+}
+
+func (h Argon2idHasher) Outdated(hash []byte) bool {
+	params, _, _, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+
+	return params.Memory < h.Memory || params.Iterations < h.Iterations || params.Parallelism < h.Parallelism
+}
+
+func decodeArgon2id(hash []byte) (Argon2idHasher, []byte, []byte, error) {
+	var params Argon2idHasher
+	var version int
+	var encodedSalt, encodedKey string
+
+	// Sscanf stops at spaces, so swap the "$" separators for them first
+	_, err := fmt.Sscanf(string(bytes.ReplaceAll(hash, []byte("$"), []byte(" "))), " argon2id v=%d m=%d,t=%d,p=%d %s %s",
+		&version, &params.Memory, &params.Iterations, &params.Parallelism, &encodedSalt, &encodedKey)
+	if err != nil || version != argon2.Version {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	salt, err := argon2Encoding.DecodeString(encodedSalt)
+	if err != nil {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	key, err := argon2Encoding.DecodeString(encodedKey)
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	return params, salt, key, nil
+}
@@ -0,0 +1,79 @@
+package data
+
+import (
+	"bytes"
+	"testing"
+
+	"greenlight.honganhpham.net/internal/assert"
+)
+
+// Small parameters keep the tests fast
+var (
+	testBcrypt   = BcryptHasher{Cost: 4}
+	testArgon2id = Argon2idHasher{Memory: 64, Iterations: 1, Parallelism: 1}
+)
+
+func TestPasswordHashers(t *testing.T) {
+	for _, hasher := range []PasswordHasher{testBcrypt, testArgon2id} {
+		hash, err := hasher.Hash("pa55word")
+		assert.NilError(t, err)
+		assert.Equal(t, hasher.Handles(hash), true)
+		assert.Equal(t, hasher.Outdated(hash), false)
+
+		match, err := hasher.Matches(hash, "pa55word")
+		assert.NilError(t, err)
+		assert.Equal(t, match, true)
+
+		match, err = hasher.Matches(hash, "wrongpassword")
+		assert.NilError(t, err)
+		assert.Equal(t, match, false)
+
+		// Salted, so the same password never hashes the same way twice
+		other, err := hasher.Hash("pa55word")
+		assert.NilError(t, err)
+		assert.Equal(t, bytes.Equal(hash, other), false)
+	}
+}
+
+func TestArgon2idFormat(t *testing.T) {
+	hash, err := testArgon2id.Hash("pa55word")
+	assert.NilError(t, err)
+	assert.StringContains(t, string(hash), "$argon2id$v=19$m=64,t=1,p=1$")
+
+	// Stronger parameters make the existing hash outdated, but it still verifies
+	stronger := Argon2idHasher{Memory: 128, Iterations: 1, Parallelism: 1}
+	assert.Equal(t, stronger.Outdated(hash), true)
+
+	match, err := stronger.Matches(hash, "pa55word")
+	assert.NilError(t, err)
+	assert.Equal(t, match, true)
+
+	for _, malformed := range []string{"$argon2id$", "$argon2id$v=18$m=64,t=1,p=1$c2FsdA$a2V5", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA$"} {
+		_, err := testArgon2id.Matches([]byte(malformed), "pa55word")
+		assert.Equal(t, err, ErrUnknownPasswordHash)
+	}
+}
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	var p password
+	assert.NilError(t, p.Set(testBcrypt, "pa55word"))
+	assert.Equal(t, p.NeedsRehash(testBcrypt), false)
+
+	// A higher cost upgrades older hashes
+	assert.Equal(t, p.NeedsRehash(BcryptHasher{Cost: 5}), true)
+
+	// Switching algorithms keeps bcrypt hashes working until they are replaced
+	assert.Equal(t, p.NeedsRehash(testArgon2id), true)
+
+	match, err := p.Matches("pa55word")
+	assert.NilError(t, err)
+	assert.Equal(t, match, true)
+
+	assert.NilError(t, p.Set(testArgon2id, "pa55word"))
+	assert.Equal(t, p.NeedsRehash(testArgon2id), false)
+
+	p.hash = []byte("md5$legacy")
+	_, err = p.Matches("pa55word")
+	assert.Equal(t, err, ErrUnknownPasswordHash)
+	assert.Equal(t, p.NeedsRehash(testArgon2id), true)
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"time"
 
 	"greenlight.honganhpham.net/internal/validator"
@@ -14,14 +15,46 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeRefresh        = "refresh"
+	ScopeEmailChange    = "email_change"
 )
 
+// Returned when an already-rotated refresh token is presented again
+var ErrTokenReused = errors.New("token reused")
+
 type Token struct {
-	Plaintext string    `json:"token"`
-	Hash      []byte    `json:"-"` // Not included in the JSON
-	UserID    int64     `json:"-"`
-	Expiry    time.Time `json:"expiry"`
-	Scope     string    `json:"-"`
+	ID           int64     `json:"-"`
+	Plaintext    string    `json:"token"`
+	Hash         []byte    `json:"-"` // Not included in the JSON
+	UserID       int64     `json:"-"`
+	Expiry       time.Time `json:"expiry"`
+	Scope        string    `json:"-"`
+	Family       []byte    `json:"-"` // Shared by the access and refresh tokens of a single login
+	SessionID    int64     `json:"-"` // Set along with the family, and like it kept across refreshes
+	Rotated      bool      `json:"-"` // A refresh token that has already been exchanged
+	PendingEmail string    `json:"-"` // Only set for the email_change scope
+	CreatedAt    time.Time `json:"-"`
+	LastUsedAt   time.Time `json:"-"` // Zero until the first use
+	UserAgent    string    `json:"-"`
+	IP           string    `json:"-"`
+}
+
+// Where a login came from, recorded with the tokens it issues
+type SessionInfo struct {
+	UserAgent string
+	IP        string
+}
+
+// A single login as shown to the user, the tokens themselves are never exposed
+type Session struct {
+	ID         int64      `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"` // Pointer as the column is NULL until the first use
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	Expiry     time.Time  `json:"expiry"`
+	Current    bool       `json:"current"` // The session making the request
 }
 
 type TokenModel struct {
@@ -30,8 +63,20 @@ type TokenModel struct {
 
 type TokenModelInterface interface {
 	New(userID int64, ttl time.Duration, scope string) (*Token, error)
+	NewInFamily(userID int64, ttl time.Duration, scope string, family []byte, info SessionInfo) (*Token, error)
+	NewEmailChange(userID int64, ttl time.Duration, email string) (*Token, error)
 	Insert(token *Token) error
+	Rotate(scope, tokenPlaintext string) (*Token, error)
+	DeleteFamily(family []byte) error
+	FamilyActive(family []byte, userID int64) (bool, error)
+	DeleteFamilyByHash(scope string, hash []byte) error
 	DeleteAllForUser(scope string, userID int64) error
+	DeleteOtherSessions(userID int64, keepHash, keepFamily []byte) error
+	DeleteExpired(batchSize int) (int64, error)
+	GetAllForUser(userID int64) ([]*Token, error)
+	Touch(hash []byte, ip string) error
+	GetSessionsForUser(userID int64, currentHash, currentFamily []byte) ([]*Session, error)
+	DeleteSession(id, userID int64) error
 }
 
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
@@ -50,21 +95,183 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
+func (m TokenModel) NewInFamily(userID int64, ttl time.Duration, scope string, family []byte, info SessionInfo) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Family = family
+	token.UserAgent = info.UserAgent
+	token.IP = info.IP
+
+	err = m.Insert(token)
+
+	return token, err
+}
+
+// Issue a token proving the user owns the new address, the address itself is stored with the token
+func (m TokenModel) NewEmailChange(userID int64, ttl time.Duration, email string) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeEmailChange)
+	if err != nil {
+		return nil, err
+	}
+
+	token.PendingEmail = email
+
+	err = m.Insert(token)
+
+	return token, err
+}
+
 func (m TokenModel) Insert(token *Token) error {
+	// Tokens joining an existing family take over its session id, the first one of a login draws a new one
 	query := `
-	INSERT INTO tokens (hash, user_id, expiry, scope)
-	VALUES ($1, $2, $3, $4)`
+	INSERT INTO tokens (hash, user_id, expiry, scope, family, pending_email, user_agent, ip, session_id)
+	VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8, CASE WHEN $5::bytea IS NULL THEN NULL ELSE
+		COALESCE((SELECT session_id FROM tokens WHERE family = $5 LIMIT 1), nextval('tokens_session_id_seq'))
+	END)
+	RETURNING id, created_at, COALESCE(session_id, 0)`
 
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope, token.Family, token.PendingEmail, token.UserAgent, token.IP}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, args...)
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&token.ID, &token.CreatedAt, &token.SessionID)
+}
+
+// Mark a token as used so it can be exchanged exactly once
+// Presenting a rotated token again means it leaked, so its whole family is revoked and ErrTokenReused returned
+// along with the token, so the caller knows whose login was revoked
+func (m TokenModel) Rotate(scope, tokenPlaintext string) (*Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Safe to call after Commit()
+	defer tx.Rollback()
+
+	token := &Token{
+		Plaintext: tokenPlaintext,
+		Hash:      HashTokenPlaintext(tokenPlaintext),
+		Scope:     scope,
+	}
 
+	var rotated bool
+
+	// Lock the row so two concurrent refreshes cannot both rotate the same token
+	query := `
+	SELECT user_id, expiry, family, rotated
+	FROM tokens
+	WHERE hash = $1 AND scope = $2
+	FOR UPDATE
+	`
+
+	err = tx.QueryRowContext(ctx, query, token.Hash, scope).Scan(&token.UserID, &token.Expiry, &token.Family, &rotated)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if time.Now().After(token.Expiry) {
+		return nil, ErrRecordNotFound
+	}
+
+	if rotated {
+		_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family = $1`, token.Family)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = tx.Commit(); err != nil {
+			return nil, err
+		}
+
+		return token, ErrTokenReused
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE tokens SET rotated = true WHERE hash = $1`, token.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Rotated = true
+
+	return token, tx.Commit()
+}
+
+// Revoke every token issued from the same login
+func (m TokenModel) DeleteFamily(family []byte) error {
+	query := `
+		DELETE FROM tokens
+		WHERE family = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, family)
 	return err
 }
 
+// Report whether the login still holds a live refresh token
+// Logout, password changes, account deletion and reuse detection all remove it
+func (m TokenModel) FamilyActive(family []byte, userID int64) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM tokens
+			WHERE family = $1 AND user_id = $2 AND scope = $3
+			AND NOT rotated AND expiry > NOW()
+		)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var active bool
+
+	err := m.DB.QueryRowContext(ctx, query, family, userID, ScopeRefresh).Scan(&active)
+	return active, err
+}
+
+// Revoke the login a token belongs to, e.g. the refresh token issued alongside an access token on logout
+// Tokens from before families were introduced are deleted on their own
+func (m TokenModel) DeleteFamilyByHash(scope string, hash []byte) error {
+	query := `
+		DELETE FROM tokens
+		WHERE (scope = $1 AND hash = $2)
+		OR family = (SELECT family FROM tokens WHERE scope = $1 AND hash = $2)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, scope, hash)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
 func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	query := `
 		DELETE FROM tokens
@@ -78,6 +285,199 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	return err
 }
 
+// Log the user out everywhere but the session holding keepHash, or the login keepFamily for signed tokens
+// Either may be nil, both nil revokes every session
+func (m TokenModel) DeleteOtherSessions(userID int64, keepHash, keepFamily []byte) error {
+	query := `
+		DELETE FROM tokens
+		WHERE user_id = $1
+		AND scope IN ($2, $3)
+		AND hash IS DISTINCT FROM $4
+		AND (family IS NULL OR family NOT IN (
+			SELECT family FROM tokens WHERE hash = $4 AND family IS NOT NULL
+			UNION ALL
+			SELECT $5::bytea WHERE $5::bytea IS NOT NULL
+		))
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, ScopeAuthentication, ScopeRefresh, keepHash, keepFamily)
+	return err
+}
+
+// Delete at most batchSize expired tokens so a large backlog does not hold a long-running lock
+func (m TokenModel) DeleteExpired(batchSize int) (int64, error) {
+	query := `
+		DELETE FROM tokens
+		WHERE hash IN (
+			SELECT hash FROM tokens
+			WHERE expiry < $1
+			LIMIT $2
+		)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now(), batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Scope and expiry of the user's live tokens, the hashes never leave the database
+func (m TokenModel) GetAllForUser(userID int64) ([]*Token, error) {
+	query := `
+		SELECT scope, expiry
+		FROM tokens
+		WHERE user_id = $1 AND expiry > $2
+		ORDER BY expiry
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	tokens := []*Token{}
+
+	for rows.Next() {
+		token := Token{UserID: userID}
+
+		err := rows.Scan(&token.Scope, &token.Expiry)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, &token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// Record that a token was just used and from where
+// Writes at most once a minute per token so busy clients do not turn every request into an UPDATE
+func (m TokenModel) Touch(hash []byte, ip string) error {
+	query := `
+		UPDATE tokens
+		SET last_used_at = NOW(), ip = $2
+		WHERE hash = $1
+		AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute' OR ip <> $2)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, hash, ip)
+	return err
+}
+
+// Group the user's live access and refresh tokens by login
+// A session is identified by the session id of its family, which neither expiry nor rotation changes
+// The current session holds currentHash, or is the login currentFamily for signed tokens
+func (m TokenModel) GetSessionsForUser(userID int64, currentHash, currentFamily []byte) ([]*Session, error) {
+	query := `
+		SELECT
+			session_id,
+			MIN(created_at),
+			MAX(last_used_at),
+			(array_agg(user_agent ORDER BY id DESC))[1],
+			(array_agg(ip ORDER BY id DESC))[1],
+			MAX(expiry),
+			COALESCE(bool_or(hash = $4 OR family = $5), false)
+		FROM tokens
+		WHERE user_id = $1
+		AND scope IN ($2, $3)
+		AND expiry > NOW()
+		AND NOT rotated
+		GROUP BY session_id
+		ORDER BY MAX(COALESCE(last_used_at, created_at)) DESC
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, ScopeAuthentication, ScopeRefresh, currentHash, currentFamily)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		var session Session
+
+		err := rows.Scan(
+			&session.ID,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.UserAgent,
+			&session.IP,
+			&session.Expiry,
+			&session.Current,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// Revoke every token of the session, scoped by user
+func (m TokenModel) DeleteSession(id, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM tokens
+		WHERE user_id = $2
+		AND scope IN ($3, $4)
+		AND session_id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID, ScopeAuthentication, ScopeRefresh)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
 	token := &Token{
 		UserID: userID,
@@ -97,12 +497,29 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 	// NoPadding to remove the "=" at the end
 	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
 
-	// Sum256() function returns an array, so we convert the result to a slice to work with it easier
-	// Since slices are more flexible to be used
-	// And we have to do it for the token.Hash field as a slice :)
-	hash := sha256.Sum256([]byte(token.Plaintext))
-	token.Hash = hash[:]
+	token.Hash = HashTokenPlaintext(token.Plaintext)
 
 	return token, nil
 
 }
+
+// Random identifier shared by the tokens of a single login
+func NewTokenFamily() ([]byte, error) {
+	family := make([]byte, 16)
+
+	_, err := rand.Read(family)
+	if err != nil {
+		return nil, err
+	}
+
+	return family, nil
+}
+
+// Return the SHA-256 hash of a plaintext token as stored in the tokens table
+func HashTokenPlaintext(tokenPlaintext string) []byte {
+	// Sum256() function returns an array, so we convert the result to a slice to work with it easier
+	// Since slices are more flexible to be used
+	// And we have to do it for the token.Hash field as a slice :)
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+	return hash[:]
+}
@@ -0,0 +1,34 @@
+package data
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"greenlight.honganhpham.net/internal/assert"
+)
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(RecoveryCodeCount)
+	assert.NilError(t, err)
+	assert.Equal(t, len(codes), RecoveryCodeCount)
+
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		assert.Equal(t, len(code), 11)
+		assert.Equal(t, code[5], byte('-'))
+		assert.Equal(t, seen[code], false)
+		seen[code] = true
+	}
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	code := "abcde-fghij"
+
+	// Typed by hand without the dash or in capitals must still match
+	for _, typed := range []string{"abcdefghij", strings.ToUpper(code)} {
+		assert.Equal(t, bytes.Equal(hashRecoveryCode(typed), hashRecoveryCode(code)), true)
+	}
+
+	assert.Equal(t, bytes.Equal(hashRecoveryCode("abcde-fghik"), hashRecoveryCode(code)), false)
+}
@@ -2,12 +2,10 @@ package data
 
 import (
 	"context"
-	"crypto/sha256"
 	"database/sql"
 	"errors"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
 	"greenlight.honganhpham.net/internal/validator"
 )
 
@@ -35,9 +33,13 @@ type UserModel struct {
 
 type UserModelInterface interface {
 	Insert(user *User) error
+	Get(id int64) (*User, error)
 	GetByEmail(email string) (*User, error)
 	GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	Update(user *User) error
+	ChangeEmail(tokenPlaintext string) (*User, error)
+	SoftDelete(id int64) error
+	DeleteSoftDeleted(before time.Time) (int64, error)
 }
 
 // Plaintext is a pointer with one possible state as `nil`
@@ -67,7 +69,7 @@ RETURNING id, created_at, version
 
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "user_emaik_key"`:
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_active_key"`:
 			return ErrDuplicateEmail
 		default:
 			return err
@@ -77,11 +79,49 @@ RETURNING id, created_at, version
 	return nil
 }
 
+func (m UserModel) Get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+SELECT id, created_at, name, email, password_hash, activated, version
+FROM users
+WHERE id = $1 AND deleted_at IS NULL
+	`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
 SELECT id, created_at, name, email, password_hash, activated, version
 FROM users
-WHERE email = $1
+WHERE email = $1 AND deleted_at IS NULL
 	`
 
 	var user User
@@ -131,11 +171,11 @@ RETURNING version
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args).Scan(&user.Version)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
 
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "user_emaik_key"`:
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_active_key"`:
 			return ErrDuplicateEmail
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
@@ -147,6 +187,136 @@ RETURNING version
 	return nil
 }
 
+// Swap in the address stored with an email_change token and burn every pending change in one transaction
+func (m UserModel) ChangeEmail(tokenPlaintext string) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Safe to call after Commit()
+	defer tx.Rollback()
+
+	var userID int64
+	var email string
+
+	// Lock the token so it cannot be redeemed twice concurrently
+	query := `
+	SELECT user_id, pending_email
+	FROM tokens
+	WHERE hash = $1 AND scope = $2 AND expiry > $3
+	FOR UPDATE
+	`
+
+	err = tx.QueryRowContext(ctx, query, HashTokenPlaintext(tokenPlaintext), ScopeEmailChange, time.Now()).Scan(&userID, &email)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	query = `
+	UPDATE users
+	SET email = $1, version = version + 1
+	WHERE id = $2 AND deleted_at IS NULL
+	RETURNING id, created_at, name, email, password_hash, activated, version
+	`
+
+	var user User
+
+	err = tx.QueryRowContext(ctx, query, email, userID).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		// Someone else registered or confirmed the address in the meantime
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_active_key"`:
+			return nil, ErrDuplicateEmail
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE scope = $1 AND user_id = $2`, ScopeEmailChange, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// Hide the account from every lookup, the row itself is kept until the grace period ends
+func (m UserModel) SoftDelete(id int64) error {
+	query := `
+UPDATE users
+SET deleted_at = NOW(), version = version + 1
+WHERE id = $1 AND deleted_at IS NULL
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Permanently remove accounts soft deleted before the given time
+// Tokens, permissions and API keys go with them through ON DELETE CASCADE
+// Failed login attempts are keyed by email, so they are purged here unless the email was registered again
+func (m UserModel) DeleteSoftDeleted(before time.Time) (int64, error) {
+	query := `
+WITH deleted AS (
+	DELETE FROM users
+	WHERE deleted_at < $1
+	RETURNING email
+), attempts AS (
+	DELETE FROM login_attempts
+	WHERE email IN (SELECT email FROM deleted)
+	AND NOT EXISTS (SELECT 1 FROM users WHERE users.email = login_attempts.email AND users.deleted_at IS NULL)
+)
+SELECT count(*) FROM deleted
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var n int64
+
+	err := m.DB.QueryRowContext(ctx, query, before).Scan(&n)
+	return n, err
+}
+
 func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(email != "", "email", "must be provided")
 	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
@@ -158,14 +328,15 @@ func ValidatePasswordPlaintext(v *validator.Validator, password string) {
 	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
 }
 
-func ValidateUser(v *validator.Validator, user *User) {
+// The policy only applies when a new password is being set, i.e. Models.PasswordPolicy
+func ValidateUser(v *validator.Validator, user *User, policy PasswordPolicy) {
 	v.Check(user.Name != "", "name", "must be provided")
 	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
 
 	ValidateEmail(v, user.Email)
 
 	if user.Password.plaintext != nil {
-		ValidatePasswordPlaintext(v, *user.Password.plaintext)
+		ValidateNewPassword(v, *user.Password.plaintext, user, policy)
 	}
 
 	// If we reach this, there could be some logic error in our codebase
@@ -174,10 +345,10 @@ func ValidateUser(v *validator.Validator, user *User) {
 	}
 }
 
-func (p *password) Set(plaintextPassword string) error {
+// Hash with the hasher configured for new hashes, i.e. Models.Hasher
+func (p *password) Set(hasher PasswordHasher, plaintextPassword string) error {
 	// Not too slow to hash and computationally expensive enough to prevent hackers
-	// Return in format $2b$[cost]$[22-character salt][31-character hash]
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	hash, err := hasher.Hash(plaintextPassword)
 
 	if err != nil {
 		return err
@@ -190,22 +361,25 @@ func (p *password) Set(plaintextPassword string) error {
 }
 
 func (p *password) Matches(plaintextPassword string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
-
+	hasher, err := hasherFor(p.hash)
 	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
-		default:
-			return false, err
-		}
+		return false, err
+	}
+
+	return hasher.Matches(p.hash, plaintextPassword)
+}
+
+// Report whether the hash should be replaced, i.e. it uses another algorithm or weaker parameters than the hasher
+func (p *password) NeedsRehash(hasher PasswordHasher) bool {
+	if !hasher.Handles(p.hash) {
+		return true
 	}
 
-	return true, nil
+	return hasher.Outdated(p.hash)
 }
 
 func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
-	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	tokenHash := HashTokenPlaintext(tokenPlaintext)
 
 	query := `
 	SELECT u.id, u.created_at, u.name, u.email, u.password_hash, u.activated, u.version
@@ -215,9 +389,10 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	WHERE t.hash = $1
 	AND t.scope = $2
 	AND t.expiry > $3
+	AND u.deleted_at IS NULL
 	`
 
-	args := []any{tokenHash[:], tokenScope, time.Now()}
+	args := []any{tokenHash, tokenScope, time.Now()}
 
 	var user User
 
@@ -0,0 +1,108 @@
+package jwt
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"greenlight.honganhpham.net/internal/assert"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestSignAndParse(t *testing.T) {
+	now := time.Now()
+
+	claims := Claims{
+		Subject:     "42",
+		Issuer:      "greenlight",
+		IssuedAt:    now.Unix(),
+		NotBefore:   now.Unix(),
+		Expiry:      now.Add(time.Hour).Unix(),
+		Activated:   true,
+		Permissions: []string{"movies:read"},
+	}
+
+	token, err := Sign(claims, testKey)
+	assert.NilError(t, err)
+
+	parsed, err := Parse(token, testKey)
+	assert.NilError(t, err)
+	assert.Equal(t, parsed.Subject, "42")
+	assert.Equal(t, parsed.Activated, true)
+	assert.Equal(t, len(parsed.Permissions), 1)
+}
+
+func TestParseRejectsInvalidTokens(t *testing.T) {
+	now := time.Now()
+
+	valid := Claims{Subject: "1", NotBefore: now.Unix(), Expiry: now.Add(time.Hour).Unix()}
+	token, err := Sign(valid, testKey)
+	assert.NilError(t, err)
+
+	parts := strings.Split(token, ".")
+
+	// Forge a payload granting extra permissions while keeping the original signature
+	forged, _ := json.Marshal(Claims{Subject: "1", Expiry: valid.Expiry, Permissions: []string{"movies:write"}})
+	unsigned, _ := json.Marshal(joseHeader{Algorithm: "none", Type: "JWT"})
+
+	tests := []struct {
+		name    string
+		token   string
+		key     []byte
+		now     time.Time
+		wantErr error
+	}{
+		{
+			name:    "Wrong Key",
+			token:   token,
+			key:     []byte("another-key"),
+			now:     now,
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "Tampered Payload",
+			token:   parts[0] + "." + encoding.EncodeToString(forged) + "." + parts[2],
+			key:     testKey,
+			now:     now,
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "Algorithm None",
+			token:   encoding.EncodeToString(unsigned) + "." + parts[1] + ".",
+			key:     testKey,
+			now:     now,
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "Malformed",
+			token:   "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU",
+			key:     testKey,
+			now:     now,
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "Not Yet Valid",
+			token:   token,
+			key:     testKey,
+			now:     now.Add(-time.Minute),
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "Expired",
+			token:   token,
+			key:     testKey,
+			now:     now.Add(2 * time.Hour),
+			wantErr: ErrExpiredToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parse(tt.token, tt.key, tt.now)
+			assert.Equal(t, errors.Is(err, tt.wantErr), true)
+		})
+	}
+}
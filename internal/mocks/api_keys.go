@@ -0,0 +1,67 @@
+package mocks
+
+import (
+	"bytes"
+	"time"
+
+	"greenlight.honganhpham.net/internal/data"
+)
+
+type MockAPIKeyModel struct {
+	keys map[int64]*data.APIKey // Map key ID with the key
+}
+
+func (m MockAPIKeyModel) New(userID int64, name string, permissions data.Permissions) (*data.APIKey, error) {
+	plaintext := "glk_MOCKKEY234567ABCDEFGHIJKLMNOPQRSTUVWXYZ234567ABCDEFG"
+
+	key := &data.APIKey{
+		ID:          int64(len(m.keys) + 1),
+		Plaintext:   plaintext,
+		Hash:        data.HashTokenPlaintext(plaintext),
+		UserID:      userID,
+		Name:        name,
+		Permissions: permissions,
+		CreatedAt:   time.Now(),
+	}
+
+	m.keys[key.ID] = key
+	return key, nil
+}
+
+func (m MockAPIKeyModel) GetAllForUser(userID int64) ([]*data.APIKey, error) {
+	keys := []*data.APIKey{}
+	for _, key := range m.keys {
+		if key.UserID == userID {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (m MockAPIKeyModel) GetForKey(keyPlaintext string) (*data.User, data.Permissions, error) {
+	hash := data.HashTokenPlaintext(keyPlaintext)
+	for _, key := range m.keys {
+		if bytes.Equal(key.Hash, hash) {
+			now := time.Now()
+			key.LastUsedAt = &now
+
+			switch key.UserID {
+			case ActivatedUser.ID:
+				return ActivatedUser, key.Permissions, nil
+			case AdminUser.ID:
+				return AdminUser, key.Permissions, nil
+			}
+		}
+	}
+	return nil, nil, data.ErrRecordNotFound
+}
+
+func (m MockAPIKeyModel) Delete(id, userID int64) error {
+	key, ok := m.keys[id]
+	if !ok || key.UserID != userID {
+		return data.ErrRecordNotFound
+	}
+
+	delete(m.keys, id)
+	return nil
+}
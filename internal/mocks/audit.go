@@ -0,0 +1,71 @@
+package mocks
+
+import (
+	"crypto/sha256"
+	"slices"
+	"time"
+
+	"greenlight.honganhpham.net/internal/data"
+)
+
+// Any key works as long as the mock chains and verifies with the same one
+var mockAuditKey = []byte("mock-audit-signing-key")
+
+type MockAuditModel struct {
+	events *[]*data.AuditEvent // Pointer so the value receivers can append
+}
+
+func (m MockAuditModel) Insert(event *data.AuditEvent) error {
+	var prev []byte
+	if n := len(*m.events); n > 0 {
+		prev = (*m.events)[n-1].Hash
+	}
+
+	event.ID = int64(len(*m.events) + 1)
+	event.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	event.Chain(mockAuditKey, prev)
+
+	*m.events = append(*m.events, event)
+	return nil
+}
+
+// Filter like the real query, newest first unless sorted by "id", without paginating
+func (m MockAuditModel) GetAll(af data.AuditFilters, filters data.Filters) ([]*data.AuditEvent, data.Metadata, error) {
+	events := []*data.AuditEvent{}
+
+	for _, e := range *m.events {
+		switch {
+		case af.ActorID != 0 && e.ActorID != af.ActorID:
+		case af.Action != "" && e.Action != af.Action:
+		case af.From != nil && e.CreatedAt.Before(*af.From):
+		case af.To != nil && e.CreatedAt.After(*af.To):
+		default:
+			events = append(events, e)
+		}
+	}
+
+	if filters.Sort != "id" {
+		slices.Reverse(events)
+	}
+
+	return events, data.Metadata{TotalRecords: len(events)}, nil
+}
+
+func (m MockAuditModel) Verify() (int64, data.AuditHead, error) {
+	if brokenAt := data.VerifyAuditChain(mockAuditKey, *m.events); brokenAt != 0 {
+		return brokenAt, data.AuditHead{}, nil
+	}
+
+	head, err := m.Head()
+	return 0, head, err
+}
+
+func (m MockAuditModel) Head() (data.AuditHead, error) {
+	n := len(*m.events)
+	if n == 0 {
+		return data.AuditHead{Hash: make([]byte, sha256.Size)}, nil
+	}
+
+	last := (*m.events)[n-1]
+	return data.AuditHead{Count: int64(n), ID: last.ID, Hash: last.Hash}, nil
+}
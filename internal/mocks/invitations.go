@@ -0,0 +1,53 @@
+package mocks
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"greenlight.honganhpham.net/internal/data"
+)
+
+type MockInvitationModel struct {
+	invitations map[int64]*data.Invitation // Map the invitation ID with the invitation
+}
+
+func (m MockInvitationModel) New(invitation *data.Invitation, ttl time.Duration) error {
+	invitation.ID = int64(len(m.invitations) + 1)
+	invitation.Code = fmt.Sprintf("MOCKINVITATION%012d", invitation.ID)
+	invitation.Hash = data.HashTokenPlaintext(invitation.Code)
+	invitation.CreatedAt = time.Now()
+	invitation.Expiry = time.Now().Add(ttl)
+
+	m.invitations[invitation.ID] = invitation
+	return nil
+}
+
+func (m MockInvitationModel) GetAll() ([]*data.Invitation, error) {
+	invitations := []*data.Invitation{}
+	for id := int64(len(m.invitations)); id > 0; id-- {
+		invitations = append(invitations, m.invitations[id])
+	}
+	return invitations, nil
+}
+
+func (m MockInvitationModel) Consume(code, email string) (*data.Invitation, error) {
+	hash := data.HashTokenPlaintext(code)
+	for _, invitation := range m.invitations {
+		if bytes.Equal(invitation.Hash, hash) && strings.EqualFold(invitation.Email, email) &&
+			invitation.UsedAt == nil && time.Now().Before(invitation.Expiry) {
+			now := time.Now()
+			invitation.UsedAt = &now
+			return invitation, nil
+		}
+	}
+	return nil, data.ErrRecordNotFound
+}
+
+func (m MockInvitationModel) Release(id int64) error {
+	if invitation, ok := m.invitations[id]; ok {
+		invitation.UsedAt = nil
+	}
+	return nil
+}
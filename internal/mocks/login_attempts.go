@@ -0,0 +1,60 @@
+package mocks
+
+import (
+	"strings"
+	"time"
+
+	"greenlight.honganhpham.net/internal/data"
+)
+
+type MockLoginAttemptModel struct {
+	attempts map[string]*data.LoginAttempt // Map the lowercased email with its attempts, mimicking citext
+}
+
+func (m MockLoginAttemptModel) Get(email string) (*data.LoginAttempt, error) {
+	attempt, ok := m.attempts[strings.ToLower(email)]
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+	return attempt, nil
+}
+
+func (m MockLoginAttemptModel) RecordFailure(email string, since time.Time) (*data.LoginAttempt, error) {
+	attempt, ok := m.attempts[strings.ToLower(email)]
+	if !ok {
+		attempt = &data.LoginAttempt{Email: email, LockedUntil: time.Now()}
+		m.attempts[strings.ToLower(email)] = attempt
+	}
+
+	if attempt.LastFailedAt.Before(since) {
+		attempt.FailedCount = 0
+	}
+
+	attempt.FailedCount++
+	attempt.LastFailedAt = time.Now()
+
+	return attempt, nil
+}
+
+func (m MockLoginAttemptModel) Lock(email string, until time.Time) error {
+	if attempt, ok := m.attempts[strings.ToLower(email)]; ok {
+		attempt.LockedUntil = until
+	}
+	return nil
+}
+
+func (m MockLoginAttemptModel) Reset(email string) error {
+	delete(m.attempts, strings.ToLower(email))
+	return nil
+}
+
+func (m MockLoginAttemptModel) DeleteStale(before time.Time) (int64, error) {
+	var n int64
+	for email, attempt := range m.attempts {
+		if attempt.LastFailedAt.Before(before) && !attempt.Locked(time.Now()) {
+			delete(m.attempts, email)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -6,25 +6,86 @@ import (
 )
 
 func NewMockModels() *data.Models {
+	tokens := newMockTokenModel()
+
 	return &data.Models{
-		Movies: MockMovieModel{},
-		Users:  newMockUserModel(),
-		Token:  newMockTokenModel(),
+		Movies:         MockMovieModel{},
+		Users:          newMockUserModel(tokens),
+		Token:          tokens,
+		Permissions:    newMockPermissionModel(),
+		APIKeys:        newMockAPIKeyModel(),
+		LoginAttempts:  newMockLoginAttemptModel(),
+		TwoFactor:      newMockTwoFactorModel(),
+		OAuth:          newMockOAuthModel(tokens),
+		Audit:          newMockAuditModel(),
+		Invitations:    newMockInvitationModel(),
+		Hasher:         mockHasher,
+		PasswordPolicy: data.PasswordPolicy{MinLength: 8, RejectCommon: true, RejectPersonal: true}, // The flag defaults
 	}
 }
 
-func newMockUserModel() *MockUserModel {
+func newMockUserModel(tokens *MockTokenModel) *MockUserModel {
 	return &MockUserModel{
 		users: map[string]*data.User{
 			"mock@example.com": mockUser,
 		},
+		tokens: tokens,
 	}
 }
 
 // NewMockTokenModel creates a new instance of MockTokenModel with initialized fields
 func newMockTokenModel() *MockTokenModel {
 	return &MockTokenModel{
-		Tokens: make(map[int64]*data.Token),
+		Tokens: make(map[string]*data.Token),
+	}
+}
+
+func newMockPermissionModel() *MockPermissionModel {
+	return &MockPermissionModel{
+		permissions: map[int64]data.Permissions{
+			ActivatedUser.ID: {data.PermissionMoviesRead, data.PermissionMoviesWrite},
+			AdminUser.ID:     {data.PermissionMoviesRead, data.PermissionMoviesWrite, data.PermissionAdminPermissions, data.PermissionAdminAudit, data.PermissionAdminInvitations},
+		},
+	}
+}
+
+func newMockAPIKeyModel() *MockAPIKeyModel {
+	return &MockAPIKeyModel{
+		keys: make(map[int64]*data.APIKey),
+	}
+}
+
+func newMockLoginAttemptModel() *MockLoginAttemptModel {
+	return &MockLoginAttemptModel{
+		attempts: make(map[string]*data.LoginAttempt),
+	}
+}
+
+func newMockTwoFactorModel() *MockTwoFactorModel {
+	return &MockTwoFactorModel{
+		enrollments:   make(map[int64]*data.TwoFactor),
+		recoveryCodes: make(map[int64]map[string]bool),
+	}
+}
+
+func newMockOAuthModel(tokens *MockTokenModel) *MockOAuthModel {
+	return &MockOAuthModel{
+		clients: make(map[string]*data.OAuthClient),
+		codes:   make(map[string]*data.OAuthCode),
+		access:  make(map[string]*data.OAuthAccess),
+		tokens:  tokens,
+	}
+}
+
+func newMockAuditModel() *MockAuditModel {
+	return &MockAuditModel{
+		events: &[]*data.AuditEvent{},
+	}
+}
+
+func newMockInvitationModel() *MockInvitationModel {
+	return &MockInvitationModel{
+		invitations: make(map[int64]*data.Invitation),
 	}
 }
 
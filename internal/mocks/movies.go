@@ -1,6 +1,7 @@
 package mocks
 
 import (
+	"strings"
 	"time"
 
 	"greenlight.honganhpham.net/internal/data"
@@ -16,6 +17,7 @@ var mockMovie = &data.Movie{
 	Runtime:   data.Runtime(120),
 	Genres:    []string{"drama"},
 	Version:   1,
+	CreatedBy: 2,
 }
 
 func (m MockMovieModel) Insert(movie *data.Movie) error {
@@ -45,3 +47,23 @@ func (m MockMovieModel) GetAll(
 	filters data.Filters) ([]*data.Movie, data.Metadata, error) {
 	return nil, data.Metadata{}, nil
 }
+
+// Match the words anywhere in the sample title, ignoring the search syntax
+func (m MockMovieModel) Search(search data.MovieSearch, filters data.Filters) ([]*data.MovieSearchResult, data.Metadata, error) {
+	for _, word := range strings.Fields(strings.ToLower(search.Query)) {
+		if !strings.Contains(strings.ToLower(mockMovie.Title), word) {
+			return []*data.MovieSearchResult{}, data.Metadata{}, nil
+		}
+	}
+
+	result := &data.MovieSearchResult{Movie: mockMovie, Rank: 0.1, Headline: mockMovie.Title}
+
+	return []*data.MovieSearchResult{result}, data.Metadata{CurrentPage: 1, PageSize: filters.PageSize, FirstPage: 1, LastPage: 1, TotalRecords: 1}, nil
+}
+
+func (m MockMovieModel) GetAllForUser(userID int64) ([]*data.Movie, error) {
+	if mockMovie.CreatedBy == userID {
+		return []*data.Movie{mockMovie}, nil
+	}
+	return []*data.Movie{}, nil
+}
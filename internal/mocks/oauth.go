@@ -0,0 +1,105 @@
+package mocks
+
+import (
+	"fmt"
+	"time"
+
+	"greenlight.honganhpham.net/internal/data"
+)
+
+type MockOAuthModel struct {
+	clients map[string]*data.OAuthClient
+	codes   map[string]*data.OAuthCode // Keyed by the code hash
+	access  map[string]*data.OAuthAccess
+	tokens  *MockTokenModel // Access tokens are generated like the other mock tokens
+}
+
+func (m MockOAuthModel) NewClient(client *data.OAuthClient, confidential bool) error {
+	client.ID = int64(len(m.clients) + 1)
+	client.ClientID = fmt.Sprintf("mockclient%d", client.ID)
+	client.CreatedAt = time.Now()
+
+	if confidential {
+		client.Secret = fmt.Sprintf("mocksecret%d", client.ID)
+		client.SecretHash = data.HashTokenPlaintext(client.Secret)
+	}
+
+	m.clients[client.ClientID] = client
+	return nil
+}
+
+func (m MockOAuthModel) GetClient(clientID string) (*data.OAuthClient, error) {
+	client, ok := m.clients[clientID]
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+	return client, nil
+}
+
+func (m MockOAuthModel) NewCode(code *data.OAuthCode, ttl time.Duration) error {
+	token, err := m.tokens.New(code.UserID, ttl, "")
+	if err != nil {
+		return err
+	}
+	delete(m.tokens.Tokens, string(token.Hash))
+
+	code.Plaintext = token.Plaintext
+	code.Hash = token.Hash
+	code.Expiry = token.Expiry
+
+	m.codes[string(code.Hash)] = code
+	return nil
+}
+
+func (m MockOAuthModel) ConsumeCode(codePlaintext string) (*data.OAuthCode, error) {
+	hash := string(data.HashTokenPlaintext(codePlaintext))
+
+	code, ok := m.codes[hash]
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+
+	delete(m.codes, hash)
+
+	if time.Now().After(code.Expiry) {
+		return nil, data.ErrRecordNotFound
+	}
+	return code, nil
+}
+
+func (m MockOAuthModel) NewAccessToken(userID, clientID int64, scopes data.Permissions, ttl time.Duration) (*data.Token, error) {
+	token, err := m.tokens.New(userID, ttl, data.ScopeOAuthAccess)
+	if err != nil {
+		return nil, err
+	}
+
+	var client string
+	for _, c := range m.clients {
+		if c.ID == clientID {
+			client = c.ClientID
+		}
+	}
+
+	m.access[string(token.Hash)] = &data.OAuthAccess{
+		UserID:   userID,
+		ClientID: client,
+		Scopes:   scopes,
+		Expiry:   token.Expiry,
+	}
+	return token, nil
+}
+
+func (m MockOAuthModel) GetAccess(tokenPlaintext string) (*data.OAuthAccess, error) {
+	hash := string(data.HashTokenPlaintext(tokenPlaintext))
+
+	access, ok := m.access[hash]
+	if !ok || time.Now().After(access.Expiry) {
+		return nil, data.ErrRecordNotFound
+	}
+
+	// Revoked together with the rest of the user's tokens
+	if _, ok := m.tokens.Tokens[hash]; !ok {
+		return nil, data.ErrRecordNotFound
+	}
+	return access, nil
+}
@@ -0,0 +1,33 @@
+package mocks
+
+import (
+	"greenlight.honganhpham.net/internal/data"
+)
+
+type MockPermissionModel struct {
+	permissions map[int64]data.Permissions // Map UserID with the permission codes
+}
+
+func (m MockPermissionModel) GetAll() (data.Permissions, error) {
+	return data.Permissions{data.PermissionMoviesRead, data.PermissionMoviesWrite, data.PermissionAdminPermissions}, nil
+}
+
+func (m MockPermissionModel) GetAllForUser(userID int64) (data.Permissions, error) {
+	return m.permissions[userID], nil
+}
+
+func (m MockPermissionModel) AddForUser(userID int64, codes ...string) error {
+	m.permissions[userID] = append(m.permissions[userID], codes...)
+	return nil
+}
+
+func (m MockPermissionModel) RemoveForUser(userID int64, codes ...string) error {
+	var remaining data.Permissions
+	for _, p := range m.permissions[userID] {
+		if !data.Permissions(codes).Include(p) {
+			remaining = append(remaining, p)
+		}
+	}
+	m.permissions[userID] = remaining
+	return nil
+}
@@ -1,13 +1,16 @@
 package mocks
 
 import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
 	"time"
 
 	"greenlight.honganhpham.net/internal/data"
 )
 
 type MockTokenModel struct {
-	Tokens        map[int64]*data.Token // Map UserID with the token
+	Tokens        map[string]*data.Token // Map the token hash with the token
 	ErrorToReturn error
 }
 
@@ -20,40 +23,285 @@ var mockToken = &data.Token{
 	Scope:     data.ScopeActivation,
 }
 
+// Make every mock token unique so several tokens can be stored for one user
+var mockTokenCounter atomic.Int64
+
 func (m MockTokenModel) New(userID int64, ttl time.Duration, scope string) (*data.Token, error) {
+	return m.NewInFamily(userID, ttl, scope, nil, data.SessionInfo{})
+}
+
+func (m MockTokenModel) NewInFamily(userID int64, ttl time.Duration, scope string, family []byte, info data.SessionInfo) (*data.Token, error) {
 	if m.ErrorToReturn != nil {
 		return nil, m.ErrorToReturn
 	}
 
+	id := mockTokenCounter.Add(1)
+
+	// 26 characters like a real token
+	plaintext := fmt.Sprintf("MOCKTOKEN%017d", id)
+
 	// Create a new token with the provided parameters
 	token := &data.Token{
-		Plaintext: "MOCKTOKEN123456789ABCDEFGHIJK", // Fixed test token
-		Hash:      []byte("mockhash"),
+		ID:        id,
+		CreatedAt: time.Now(),
+		UserAgent: info.UserAgent,
+		IP:        info.IP,
+		Plaintext: plaintext,
+		Hash:      data.HashTokenPlaintext(plaintext),
 		UserID:    userID,
 		Expiry:    time.Now().Add(ttl),
 		Scope:     scope,
+		Family:    family,
+	}
+
+	// Like the database, the first token of a login draws the session id and the rest of the family shares it
+	if family != nil {
+		token.SessionID = id
+		for _, existing := range m.Tokens {
+			if bytes.Equal(existing.Family, family) {
+				token.SessionID = existing.SessionID
+			}
+		}
 	}
 
 	// Store the token in our mock storage
-	m.Tokens[userID] = token
+	m.Tokens[string(token.Hash)] = token
 
 	return token, nil
 }
 
+func (m MockTokenModel) NewEmailChange(userID int64, ttl time.Duration, email string) (*data.Token, error) {
+	token, err := m.NewInFamily(userID, ttl, data.ScopeEmailChange, nil, data.SessionInfo{})
+	if err != nil {
+		return nil, err
+	}
+
+	token.PendingEmail = email
+	return token, nil
+}
+
 func (m MockTokenModel) Insert(token *data.Token) error {
 	if m.ErrorToReturn != nil {
 		return m.ErrorToReturn
 	}
 
-	m.Tokens[token.UserID] = token
+	m.Tokens[string(token.Hash)] = token
 	return nil
 }
 
+func (m MockTokenModel) Rotate(scope, tokenPlaintext string) (*data.Token, error) {
+	if m.ErrorToReturn != nil {
+		return nil, m.ErrorToReturn
+	}
+
+	token, ok := m.Tokens[string(data.HashTokenPlaintext(tokenPlaintext))]
+	if !ok || token.Scope != scope || time.Now().After(token.Expiry) {
+		return nil, data.ErrRecordNotFound
+	}
+
+	if token.Rotated {
+		m.DeleteFamily(token.Family)
+		return token, data.ErrTokenReused
+	}
+
+	token.Rotated = true
+	return token, nil
+}
+
+func (m MockTokenModel) DeleteFamily(family []byte) error {
+	if m.ErrorToReturn != nil {
+		return m.ErrorToReturn
+	}
+
+	for hash, token := range m.Tokens {
+		if bytes.Equal(token.Family, family) {
+			delete(m.Tokens, hash)
+		}
+	}
+	return nil
+}
+
+func (m MockTokenModel) FamilyActive(family []byte, userID int64) (bool, error) {
+	if m.ErrorToReturn != nil {
+		return false, m.ErrorToReturn
+	}
+
+	for _, token := range m.Tokens {
+		if bytes.Equal(token.Family, family) && token.UserID == userID && token.Scope == data.ScopeRefresh &&
+			!token.Rotated && token.Expiry.After(time.Now()) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m MockTokenModel) DeleteFamilyByHash(scope string, hash []byte) error {
+	if m.ErrorToReturn != nil {
+		return m.ErrorToReturn
+	}
+
+	token, ok := m.Tokens[string(hash)]
+	if !ok || token.Scope != scope {
+		return data.ErrRecordNotFound
+	}
+
+	if token.Family == nil {
+		delete(m.Tokens, string(hash))
+		return nil
+	}
+
+	return m.DeleteFamily(token.Family)
+}
+
 func (m MockTokenModel) DeleteAllForUser(scope string, userID int64) error {
 	if m.ErrorToReturn != nil {
 		return m.ErrorToReturn
 	}
-	delete(m.Tokens, userID)
+
+	for hash, token := range m.Tokens {
+		if token.Scope == scope && token.UserID == userID {
+			delete(m.Tokens, hash)
+		}
+	}
+	return nil
+}
+
+func (m MockTokenModel) DeleteOtherSessions(userID int64, keepHash, keepFamily []byte) error {
+	if m.ErrorToReturn != nil {
+		return m.ErrorToReturn
+	}
+
+	if current, ok := m.Tokens[string(keepHash)]; ok && current.Family != nil {
+		keepFamily = current.Family
+	}
+
+	for hash, token := range m.Tokens {
+		if token.UserID != userID || (token.Scope != data.ScopeAuthentication && token.Scope != data.ScopeRefresh) {
+			continue
+		}
+		if bytes.Equal(token.Hash, keepHash) || (token.Family != nil && bytes.Equal(token.Family, keepFamily)) {
+			continue
+		}
+		delete(m.Tokens, hash)
+	}
+	return nil
+}
+
+func (m MockTokenModel) DeleteExpired(batchSize int) (int64, error) {
+	if m.ErrorToReturn != nil {
+		return 0, m.ErrorToReturn
+	}
+
+	var deleted int64
+	for hash, token := range m.Tokens {
+		if deleted == int64(batchSize) {
+			break
+		}
+		if token.Expiry.Before(time.Now()) {
+			delete(m.Tokens, hash)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+func (m MockTokenModel) GetAllForUser(userID int64) ([]*data.Token, error) {
+	if m.ErrorToReturn != nil {
+		return nil, m.ErrorToReturn
+	}
+
+	tokens := []*data.Token{}
+	for _, token := range m.Tokens {
+		if token.UserID == userID && token.Expiry.After(time.Now()) {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+func (m MockTokenModel) Touch(hash []byte, ip string) error {
+	if m.ErrorToReturn != nil {
+		return m.ErrorToReturn
+	}
+
+	if token, ok := m.Tokens[string(hash)]; ok {
+		token.LastUsedAt = time.Now()
+		token.IP = ip
+	}
+	return nil
+}
+
+func (m MockTokenModel) GetSessionsForUser(userID int64, currentHash, currentFamily []byte) ([]*data.Session, error) {
+	if m.ErrorToReturn != nil {
+		return nil, m.ErrorToReturn
+	}
+
+	sessions := make(map[int64]*data.Session)
+	latest := make(map[int64]int64)
+
+	for _, token := range m.Tokens {
+		if token.UserID != userID || token.Rotated || token.Expiry.Before(time.Now()) {
+			continue
+		}
+		if token.Scope != data.ScopeAuthentication && token.Scope != data.ScopeRefresh {
+			continue
+		}
+
+		key := token.SessionID
+
+		session, ok := sessions[key]
+		if !ok {
+			session = &data.Session{ID: token.SessionID, CreatedAt: token.CreatedAt}
+			sessions[key] = session
+		}
+
+		if token.CreatedAt.Before(session.CreatedAt) {
+			session.CreatedAt = token.CreatedAt
+		}
+		session.Current = session.Current || bytes.Equal(token.Hash, currentHash) || (currentFamily != nil && bytes.Equal(token.Family, currentFamily))
+
+		if token.Expiry.After(session.Expiry) {
+			session.Expiry = token.Expiry
+		}
+		if !token.LastUsedAt.IsZero() && (session.LastUsedAt == nil || token.LastUsedAt.After(*session.LastUsedAt)) {
+			lastUsedAt := token.LastUsedAt
+			session.LastUsedAt = &lastUsedAt
+		}
+		if token.ID > latest[key] {
+			latest[key] = token.ID
+			session.UserAgent = token.UserAgent
+			session.IP = token.IP
+		}
+	}
+
+	result := []*data.Session{}
+	for _, session := range sessions {
+		result = append(result, session)
+	}
+	return result, nil
+}
+
+func (m MockTokenModel) DeleteSession(id, userID int64) error {
+	if m.ErrorToReturn != nil {
+		return m.ErrorToReturn
+	}
+
+	deleted := 0
+	for hash, token := range m.Tokens {
+		if token.SessionID != id || token.UserID != userID {
+			continue
+		}
+		if token.Scope != data.ScopeAuthentication && token.Scope != data.ScopeRefresh {
+			continue
+		}
+		delete(m.Tokens, hash)
+		deleted++
+	}
+
+	if id < 1 || deleted == 0 {
+		return data.ErrRecordNotFound
+	}
 	return nil
 }
 
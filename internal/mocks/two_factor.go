@@ -0,0 +1,77 @@
+package mocks
+
+import (
+	"strings"
+	"time"
+
+	"greenlight.honganhpham.net/internal/data"
+)
+
+type MockTwoFactorModel struct {
+	enrollments   map[int64]*data.TwoFactor
+	recoveryCodes map[int64]map[string]bool // Normalised plaintext codes, the real model only keeps hashes
+}
+
+func normaliseRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(code, "-", ""))
+}
+
+func (m MockTwoFactorModel) Get(userID int64) (*data.TwoFactor, error) {
+	tf, ok := m.enrollments[userID]
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+	return tf, nil
+}
+
+func (m MockTwoFactorModel) Enroll(userID int64, secret string) error {
+	if tf, ok := m.enrollments[userID]; ok && tf.Enabled {
+		return data.ErrEditConflict
+	}
+
+	m.enrollments[userID] = &data.TwoFactor{UserID: userID, Secret: secret, CreatedAt: time.Now()}
+	return nil
+}
+
+func (m MockTwoFactorModel) Enable(userID int64, step int64, recoveryCodes []string) error {
+	tf, ok := m.enrollments[userID]
+	if !ok || tf.Enabled {
+		return data.ErrEditConflict
+	}
+
+	tf.Enabled = true
+	tf.LastUsedStep = step
+
+	m.recoveryCodes[userID] = make(map[string]bool)
+	for _, code := range recoveryCodes {
+		m.recoveryCodes[userID][normaliseRecoveryCode(code)] = true
+	}
+	return nil
+}
+
+func (m MockTwoFactorModel) Disable(userID int64) error {
+	delete(m.enrollments, userID)
+	delete(m.recoveryCodes, userID)
+	return nil
+}
+
+func (m MockTwoFactorModel) UseStep(userID int64, step int64) error {
+	tf, ok := m.enrollments[userID]
+	if !ok || !tf.Enabled || tf.LastUsedStep >= step {
+		return data.ErrRecordNotFound
+	}
+
+	tf.LastUsedStep = step
+	return nil
+}
+
+func (m MockTwoFactorModel) UseRecoveryCode(userID int64, code string) error {
+	code = normaliseRecoveryCode(code)
+
+	if !m.recoveryCodes[userID][code] {
+		return data.ErrRecordNotFound
+	}
+
+	delete(m.recoveryCodes[userID], code)
+	return nil
+}
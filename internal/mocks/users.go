@@ -7,7 +7,8 @@ import (
 )
 
 type MockUserModel struct {
-	users map[string]*data.User
+	users  map[string]*data.User
+	tokens *MockTokenModel // Shared with the token mock so email changes can be redeemed
 }
 
 var mockUser = &data.User{
@@ -20,6 +21,40 @@ var mockUser = &data.User{
 	Version:   1,
 }
 
+// Activated user holding both movie permissions
+var ActivatedUser = &data.User{
+	ID:        2,
+	CreatedAt: time.Now(),
+	Name:      "Activated User",
+	Email:     "activated@example.com",
+	Activated: true,
+	Version:   1,
+}
+
+// Activated user allowed to manage other users' permissions
+var AdminUser = &data.User{
+	ID:        3,
+	CreatedAt: time.Now(),
+	Name:      "Admin User",
+	Email:     "admin@example.com",
+	Activated: true,
+	Version:   1,
+}
+
+// Plaintext password shared by every mock user
+const MockPassword = "pa55word"
+
+// Same as the default of the password-bcrypt-cost flag
+var mockHasher data.PasswordHasher = data.BcryptHasher{Cost: 12}
+
+func init() {
+	for _, user := range []*data.User{mockUser, ActivatedUser, AdminUser} {
+		if err := user.Password.Set(mockHasher, MockPassword); err != nil {
+			panic(err)
+		}
+	}
+}
+
 func (m MockUserModel) Insert(user *data.User) error {
 	if _, exists := m.users[user.Email]; exists {
 		return data.ErrDuplicateEmail
@@ -33,11 +68,24 @@ func (m MockUserModel) Insert(user *data.User) error {
 	return nil
 }
 
+// Return a copy so handlers mutating the user cannot leak into other tests
+func (m MockUserModel) Get(id int64) (*data.User, error) {
+	for _, user := range []*data.User{mockUser, ActivatedUser, AdminUser} {
+		if user.ID == id {
+			u := *user
+			return &u, nil
+		}
+	}
+	return nil, data.ErrRecordNotFound
+}
+
 // GetByEmail simulates fetching a user by email
 func (m MockUserModel) GetByEmail(email string) (*data.User, error) {
 	switch email {
 	case "mock@example.com":
 		return mockUser, nil
+	case ActivatedUser.Email:
+		return ActivatedUser, nil
 	default:
 		return nil, data.ErrRecordNotFound
 	}
@@ -47,6 +95,52 @@ func (m MockUserModel) Update(user *data.User) error {
 	return nil
 }
 
+// Tokens stored in the token mock are resolved properly, any other token belongs to mockUser
 func (m MockUserModel) GetForToken(tokenScope, tokenPlaintext string) (*data.User, error) {
-	return mockUser, nil
+	token, ok := m.tokens.Tokens[string(data.HashTokenPlaintext(tokenPlaintext))]
+	if !ok {
+		return mockUser, nil
+	}
+
+	if token.Scope != tokenScope || time.Now().After(token.Expiry) {
+		return nil, data.ErrRecordNotFound
+	}
+
+	return m.Get(token.UserID)
+}
+
+func (m MockUserModel) SoftDelete(id int64) error {
+	if _, err := m.Get(id); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m MockUserModel) DeleteSoftDeleted(before time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (m MockUserModel) ChangeEmail(tokenPlaintext string) (*data.User, error) {
+	hash := string(data.HashTokenPlaintext(tokenPlaintext))
+
+	token, ok := m.tokens.Tokens[hash]
+	if !ok || token.Scope != data.ScopeEmailChange || time.Now().After(token.Expiry) {
+		return nil, data.ErrRecordNotFound
+	}
+
+	if _, err := m.GetByEmail(token.PendingEmail); err == nil {
+		return nil, data.ErrDuplicateEmail
+	}
+
+	user, err := m.Get(token.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Email = token.PendingEmail
+	user.Version++
+
+	m.tokens.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+
+	return user, nil
 }
@@ -10,14 +10,14 @@ type Limiter struct {
 }
 
 type LimiterConfig struct {
-	RequestsPerSecond int
+	RequestsPerSecond float64 // Below 1 to allow one request every few seconds
 	BurstSize         int
 	QueueSize         int
 	Enabled           bool
 }
 
 func New(cfg LimiterConfig) *Limiter {
-	interval := time.Second / time.Duration(cfg.RequestsPerSecond)
+	interval := time.Duration(float64(time.Second) / cfg.RequestsPerSecond)
 
 	rl := &Limiter{
 		requests:      make(chan int, cfg.QueueSize),
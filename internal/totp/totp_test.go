@@ -0,0 +1,65 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"greenlight.honganhpham.net/internal/assert"
+)
+
+// The SHA1 seed "12345678901234567890" from RFC 6238 appendix B, in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// RFC 6238 appendix B vectors, truncated to 6 digits
+	tests := []struct {
+		unix     int64
+		expected string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		code, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		assert.NilError(t, err)
+		assert.Equal(t, code, tt.expected)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+
+	tests := []struct {
+		name  string
+		code  string
+		valid bool
+	}{
+		{name: "Current Step", code: "050471", valid: true},
+		{name: "Previous Step", code: "081804", valid: true},
+		{name: "Wrong Code", code: "000000", valid: false},
+		{name: "Wrong Length", code: "05047", valid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ok := Validate(rfcSecret, tt.code, now)
+			assert.Equal(t, ok, tt.valid)
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	assert.NilError(t, err)
+	assert.Equal(t, len(secret), 32)
+
+	uri := URI("Greenlight", "alice@example.com", secret)
+	assert.Equal(t, strings.HasPrefix(uri, "otpauth://totp/Greenlight:alice@example.com?"), true)
+	assert.StringContains(t, uri, "secret="+secret)
+}
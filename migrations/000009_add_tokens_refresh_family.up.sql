@@ -0,0 +1,6 @@
+-- Tokens issued by the same login share a family, so a reused refresh token can revoke all of them
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS family bytea;
+
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS rotated bool NOT NULL DEFAULT false;
+
+CREATE INDEX IF NOT EXISTS tokens_family_idx ON tokens (family);
@@ -0,0 +1,7 @@
+-- Keyed by email rather than user so unknown accounts are throttled exactly like real ones
+CREATE TABLE IF NOT EXISTS login_attempts (
+    email citext PRIMARY KEY,
+    failed_count integer NOT NULL DEFAULT 0,
+    last_failed_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    locked_until timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS movies_user_id_idx;
+
+ALTER TABLE movies DROP COLUMN IF EXISTS user_id;
+
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
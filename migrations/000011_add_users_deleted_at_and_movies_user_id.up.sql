@@ -0,0 +1,7 @@
+-- Soft-deleted accounts are hidden from every lookup and hard deleted after a grace period
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+
+-- Author of a movie, kept as NULL once the author's account is hard deleted
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS user_id bigint REFERENCES users ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS movies_user_id_idx ON movies (user_id);
@@ -0,0 +1,2 @@
+-- The new address waiting to be confirmed by an email_change token
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS pending_email citext;
@@ -0,0 +1,14 @@
+-- The secret has to stay readable to compute codes, so it is stored as is
+CREATE TABLE IF NOT EXISTS two_factor (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    secret text NOT NULL,
+    enabled bool NOT NULL DEFAULT false, -- Only once the user proved their app produces valid codes
+    last_used_step bigint NOT NULL DEFAULT 0, -- Stops a code being replayed within its window
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    hash bytea NOT NULL,
+    PRIMARY KEY (user_id, hash)
+);
@@ -0,0 +1,4 @@
+ALTER TABLE tokens DROP COLUMN IF EXISTS scopes;
+ALTER TABLE tokens DROP COLUMN IF EXISTS client_id;
+DROP TABLE IF EXISTS oauth_codes;
+DROP TABLE IF EXISTS oauth_clients;
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS oauth_clients (
+    id bigserial PRIMARY KEY,
+    client_id text UNIQUE NOT NULL,
+    secret_hash bytea, -- NULL for public clients e.g. mobile apps, which rely on PKCE alone
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE, -- Owner, also the subject of client credentials tokens
+    name text NOT NULL,
+    redirect_uris text[] NOT NULL,
+    scopes text[] NOT NULL, -- Permission codes the client may ask for
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS oauth_codes (
+    hash bytea PRIMARY KEY,
+    client_id bigint NOT NULL REFERENCES oauth_clients ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    redirect_uri text NOT NULL,
+    scopes text[] NOT NULL,
+    code_challenge text NOT NULL,
+    expiry timestamp(0) with time zone NOT NULL
+);
+
+-- Access tokens live with the other tokens, tied to the client that obtained them
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS client_id bigint REFERENCES oauth_clients ON DELETE CASCADE;
+
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS scopes text[];
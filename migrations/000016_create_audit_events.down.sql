@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'admin:audit';
+
+DROP TABLE IF EXISTS audit_events;
@@ -0,0 +1,23 @@
+-- No foreign keys, so the trail outlives purged users and deleted movies
+CREATE TABLE IF NOT EXISTS audit_events (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL,
+    actor_id bigint,
+    action text NOT NULL,
+    target_type text NOT NULL DEFAULT '',
+    target_id bigint,
+    ip text NOT NULL DEFAULT '',
+    metadata jsonb NOT NULL DEFAULT '{}',
+    prev_hash bytea NOT NULL,
+    hash bytea NOT NULL UNIQUE
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_actor_id_idx ON audit_events (actor_id);
+
+CREATE INDEX IF NOT EXISTS audit_events_action_idx ON audit_events (action);
+
+CREATE INDEX IF NOT EXISTS audit_events_created_at_idx ON audit_events (created_at);
+
+INSERT INTO permissions (code)
+VALUES
+    ('admin:audit');
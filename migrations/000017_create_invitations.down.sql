@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'admin:invitations';
+
+DROP TABLE IF EXISTS invitations;
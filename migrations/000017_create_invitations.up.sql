@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS invitations (
+    id bigserial PRIMARY KEY,
+    hash bytea NOT NULL UNIQUE,
+    email citext NOT NULL,
+    permissions text[] NOT NULL DEFAULT '{}',
+    invited_by bigint REFERENCES users ON DELETE SET NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expiry timestamp(0) with time zone NOT NULL,
+    used_at timestamp(0) with time zone
+);
+
+INSERT INTO permissions (code)
+VALUES
+    ('admin:invitations');
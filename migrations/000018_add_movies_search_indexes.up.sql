@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS movies_title_english_idx ON movies USING GIN (to_tsvector ('english', title));
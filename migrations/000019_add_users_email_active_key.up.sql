@@ -0,0 +1,4 @@
+-- Only accounts that are not soft deleted claim their email, so it can be registered again during the grace period
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_active_key ON users (email) WHERE deleted_at IS NULL;
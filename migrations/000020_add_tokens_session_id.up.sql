@@ -0,0 +1,19 @@
+-- A login keeps one id for its whole life, while the ids of its tokens change with every refresh
+CREATE SEQUENCE IF NOT EXISTS tokens_session_id_seq;
+
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS session_id bigint;
+
+-- Existing logins keep the id they are currently listed under
+UPDATE tokens SET session_id = sessions.id
+FROM (
+    SELECT COALESCE(family, hash) AS key, MIN(id) AS id
+    FROM tokens
+    WHERE scope IN ('authentication', 'refresh')
+    GROUP BY COALESCE(family, hash)
+) AS sessions
+WHERE COALESCE(tokens.family, tokens.hash) = sessions.key
+AND tokens.scope IN ('authentication', 'refresh');
+
+SELECT setval('tokens_session_id_seq', COALESCE((SELECT MAX(id) FROM tokens), 0) + 1, false);
+
+CREATE INDEX IF NOT EXISTS tokens_session_id_idx ON tokens (session_id);